@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// allowedNamespaces returns the namespaces the org may view and whether the
+// org is restricted at all.
+func (s *appSettings) allowedNamespaces(orgID int64) ([]string, bool) {
+	namespaces, ok := s.OrgNamespaceAllowlist[orgID]
+	return namespaces, ok
+}
+
+// namespaceScoped rejects requests for namespaces outside the calling org's
+// allowlist with a 403. Orgs without an allowlist may view every namespace;
+// restricted orgs must name the namespaces they ask for, unless a default
+// namespace is configured, which is then checked instead.
+func (a *App) namespaceScoped(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if a.settings != nil {
+			orgID := backend.PluginConfigFromContext(req.Context()).OrgID
+			if allowed, restricted := a.settings.allowedNamespaces(orgID); restricted {
+				namespaces := req.URL.Query()["namespace"]
+				if len(namespaces) == 0 && a.settings.DefaultNamespace != "" {
+					namespaces = []string{a.settings.DefaultNamespace}
+				}
+				if len(namespaces) == 0 {
+					writeJSONError(w, http.StatusForbidden, "a namespace must be selected")
+					return
+				}
+				for _, namespace := range namespaces {
+					if !slices.Contains(allowed, namespace) {
+						writeJSONError(w, http.StatusForbidden, fmt.Sprintf("access to namespace %q is not allowed", namespace))
+						return
+					}
+				}
+			}
+		}
+		h(w, req)
+	}
+}
+
+// objectNamespaceScoped rejects responses holding an object outside the
+// calling org's allowlist with a 403, for routes addressing an object by UID
+// rather than by namespace. For restricted orgs the response is buffered and
+// its namespace field checked; objects without a namespace are rejected too.
+// Error responses are relayed as they are.
+func (a *App) objectNamespaceScoped(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var allowed []string
+		restricted := false
+		if a.settings != nil {
+			allowed, restricted = a.settings.allowedNamespaces(backend.PluginConfigFromContext(req.Context()).OrgID)
+		}
+		if !restricted {
+			h(w, req)
+			return
+		}
+
+		// Ask for the full uncompressed object so that it can be checked,
+		// even for HEAD requests
+		head := req.Method == http.MethodHead
+		req = req.Clone(req.Context())
+		req.Method = http.MethodGet
+		for _, key := range []string{"Accept-Encoding", "If-None-Match", "If-Modified-Since"} {
+			req.Header.Del(key)
+		}
+		resp := newSharedResponse()
+		h(resp, req)
+		if resp.status == http.StatusOK {
+			var object struct {
+				Namespace string `json:"namespace"`
+			}
+			if err := json.Unmarshal(resp.body.Bytes(), &object); err != nil || !slices.Contains(allowed, object.Namespace) {
+				writeJSONError(w, http.StatusForbidden, "access to the namespace of the resource is not allowed")
+				return
+			}
+		}
+		if head {
+			resp.body.Reset()
+			resp.header.Del("Content-Length")
+		}
+		resp.replay(w, "")
+	}
+}
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestNamespaceScoped(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Resources are named after their namespace
+		if namespace, ok := strings.CutPrefix(r.URL.Path, "/api/v1/resources/"); ok {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"uid":"` + namespace + `","namespace":"` + namespace + `"}`))
+		}
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","orgNamespaceAllowlist":{"2":["team-a","team-b"]}}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	for _, tc := range []struct {
+		name      string
+		orgID     int64
+		method    string
+		path      string
+		expStatus int
+	}{
+		{name: "unrestricted org", orgID: 1, path: "/graph?namespace=kube-system", expStatus: http.StatusOK},
+		{name: "unrestricted org without namespace", orgID: 1, path: "/resources", expStatus: http.StatusOK},
+		{name: "allowed namespace", orgID: 2, path: "/graph?namespace=team-a", expStatus: http.StatusOK},
+		{name: "allowed namespaces", orgID: 2, path: "/resources?namespace=team-a&namespace=team-b", expStatus: http.StatusOK},
+		{name: "other namespace", orgID: 2, path: "/graph?namespace=kube-system", expStatus: http.StatusForbidden},
+		{name: "one other namespace", orgID: 2, path: "/resources?namespace=team-a&namespace=kube-system", expStatus: http.StatusForbidden},
+		{name: "missing namespace", orgID: 2, path: "/resources", expStatus: http.StatusForbidden},
+		{name: "releases in allowed namespace", orgID: 2, path: "/releases?namespace=team-a", expStatus: http.StatusOK},
+		{name: "releases in other namespace", orgID: 2, path: "/releases?namespace=kube-system", expStatus: http.StatusForbidden},
+		{name: "releases without namespace", orgID: 2, path: "/releases", expStatus: http.StatusForbidden},
+		{name: "workloads in allowed namespace", orgID: 2, path: "/workloads?namespace=team-b", expStatus: http.StatusOK},
+		{name: "workloads in other namespace", orgID: 2, path: "/workloads?namespace=kube-system", expStatus: http.StatusForbidden},
+		{name: "events in allowed namespace", orgID: 2, path: "/events?namespace=team-a", expStatus: http.StatusOK},
+		{name: "events in other namespace", orgID: 2, path: "/events?namespace=kube-system", expStatus: http.StatusForbidden},
+		{name: "unrestricted org resource", orgID: 1, path: "/resources/kube-system", expStatus: http.StatusOK},
+		{name: "resource in allowed namespace", orgID: 2, path: "/resources/team-a", expStatus: http.StatusOK},
+		{name: "resource in other namespace", orgID: 2, path: "/resources/kube-system", expStatus: http.StatusForbidden},
+		{name: "head resource in allowed namespace", orgID: 2, method: http.MethodHead, path: "/resources/team-a", expStatus: http.StatusOK},
+		{name: "head resource in other namespace", orgID: 2, method: http.MethodHead, path: "/resources/kube-system", expStatus: http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: tc.orgID})
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(method, tc.path, nil).WithContext(ctx))
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+		})
+	}
+}
@@ -2,11 +2,20 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"golang.org/x/sync/singleflight"
 )
 
 // Make sure App implements required interfaces. This is important to do
@@ -17,37 +26,273 @@ var (
 	_ backend.CallResourceHandler   = (*App)(nil)
 	_ instancemgmt.InstanceDisposer = (*App)(nil)
 	_ backend.CheckHealthHandler    = (*App)(nil)
+	_ backend.StreamHandler         = (*App)(nil)
 )
 
 // App is an example app plugin with a backend which can respond to data queries.
 type App struct {
-	backend.CallResourceHandler
+	// mux serves the resource calls, see CallResource. resources is the
+	// httpadapter around it used for the responses sent whole.
+	mux       *http.ServeMux
+	resources backend.CallResourceHandler
+
+	// routes are the patterns of the enabled routes, see registerRoutes.
+	routes []string
+
+	// settings are parsed once when the instance is created. If parsing
+	// failed, settingsErr holds the reason and is reported by CheckHealth.
+	settings    *appSettings
+	settingsErr error
+
+	// client is used for direct calls to the state server and owns the pooled
+	// transport shared by everything else. healthClient shares that transport
+	// but uses the short health timeout.
+	client       *http.Client
+	healthClient *http.Client
+
+	// streamClient has no timeout since watches are long-lived.
+	streamClient *http.Client
+
+	// reconnectBackoff is the delay before a stream reconnects after the
+	// given number of consecutive failures, see streamBackoff.
+	reconnectBackoff func(failures int) time.Duration
+
+	// transport is used by the reverse proxy and the plugin's own calls. On
+	// top of the shared client's transport it retries transient failures,
+	// when enabled trips a circuit breaker after repeated ones, and caps the
+	// concurrent requests.
+	transport http.RoundTripper
+
+	// cache holds upstream responses when caching is enabled, nil otherwise.
+	cache *responseCache
+
+	// limiter caps the request rate to the state server across all routes,
+	// nil when unlimited.
+	limiter *rateLimiter
+
+	// logSampler picks the proxied requests that are logged, nil when all
+	// are.
+	logSampler *logSampler
+
+	// graphCache serves graphs from a cache refreshed in the background when
+	// caching is enabled, nil otherwise.
+	graphCache *graphCache
+
+	// readiness caches state server health checks for /ready.
+	readiness *readinessChecker
+
+	// versions caches the state server version for /version.
+	versions versionCache
+
+	// flights deduplicates identical concurrent GET requests.
+	flights singleflight.Group
+
+	// ready is closed once the warm-up started by NewApp is done, nil if
+	// there is none.
+	ready chan struct{}
+
+	// stopBackground cancels the background work started by NewApp.
+	stopBackground context.CancelFunc
+
+	// inFlight tracks proxied requests so Dispose can let them finish.
+	// Once closed is set no new requests are accepted.
+	shutdownMu sync.RWMutex
+	closed     bool
+	inFlight   sync.WaitGroup
 }
 
+// disposeTimeout bounds how long Dispose waits for in-flight requests.
+const disposeTimeout = 10 * time.Second
+
 // NewApp creates a new example *App instance.
-func NewApp(_ context.Context, _ backend.AppInstanceSettings) (instancemgmt.Instance, error) {
+func NewApp(_ context.Context, settings backend.AppInstanceSettings) (instancemgmt.Instance, error) {
 	var app App
+	app.reconnectBackoff = func(failures int) time.Duration {
+		return streamBackoff(failures, rand.Float64())
+	}
+
+	app.settings, app.settingsErr = loadSettings(settings)
+	if app.settingsErr == nil {
+		app.client, app.settingsErr = newIndexerClient(app.settings)
+	}
+	if app.settingsErr != nil {
+		log.DefaultLogger.Error("Invalid app settings", "error", app.settingsErr)
+	} else {
+		app.healthClient = &http.Client{Transport: app.client.Transport, Timeout: healthzTimeout}
+		app.streamClient = &http.Client{Transport: app.client.Transport}
+		app.transport = &retryTransport{
+			next:          app.client.Transport,
+			maxRetries:    app.settings.MaxRetries,
+			maxRetryAfter: app.settings.maxRetryAfter(),
+		}
+		if threshold := app.settings.CircuitBreakerThreshold; threshold > 0 {
+			app.transport = newBreakerTransport(app.transport, threshold, app.settings.circuitBreakerCooldown())
+		}
+		if app.settings.FollowRedirects {
+			app.transport = &redirectTransport{next: app.transport}
+		}
+		if limit := app.settings.MaxConcurrentUpstream; limit > 0 {
+			app.transport = &concurrencyTransport{next: app.transport, slots: newConcurrencyLimiter(limit)}
+		}
+		var background context.Context
+		background, app.stopBackground = context.WithCancel(context.Background())
+		if ttl := app.settings.cacheTTL(); ttl > 0 {
+			app.cache = newResponseCache(ttl)
+			app.graphCache = newGraphCache(background, ttl, app.fetchGraph)
+			go app.graphCache.run()
+		}
+		app.readiness = newReadinessChecker(background, app.probeHealth)
+		if interval := app.settings.healthPollInterval(); interval > 0 {
+			app.readiness.poll(interval)
+		}
+		if rate := app.settings.LogSampleRate; rate > 1 {
+			app.logSampler = newLogSampler(rate)
+		}
+		if rps := app.settings.MaxRequestsPerSecond; rps > 0 {
+			app.limiter = newRateLimiter(rps)
+		}
+		if timeout := app.settings.warmupTimeout(); timeout > 0 {
+			app.ready = make(chan struct{})
+			go app.warmUp(background, timeout)
+		}
+	}
 
 	// Use a httpadapter (provided by the SDK) for resource calls. This allows us
 	// to use a *http.ServeMux for resource calls, so we can map multiple routes
 	// to CallResource without having to implement extra logic.
-	mux := http.NewServeMux()
-	app.registerRoutes(mux)
-	app.CallResourceHandler = httpadapter.New(mux)
+	app.mux = http.NewServeMux()
+	if err := app.registerRoutes(app.mux); err != nil {
+		return nil, err
+	}
+	app.resources = httpadapter.New(app.mux)
 
 	return &app, nil
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
-// created.
+// created. New proxied requests are refused while in-flight ones get up to
+// disposeTimeout to complete before idle upstream connections are closed.
 func (a *App) Dispose() {
-	// cleanup
+	if a.stopBackground != nil {
+		a.stopBackground()
+	}
+
+	a.shutdownMu.Lock()
+	a.closed = true
+	a.shutdownMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		a.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(disposeTimeout):
+		log.DefaultLogger.Warn("Timed out waiting for in-flight requests", "timeout", disposeTimeout)
+	}
+
+	if a.client != nil {
+		a.client.CloseIdleConnections()
+	}
+}
+
+// track registers an in-flight request. It returns false once the instance is
+// being disposed; otherwise the caller must call a.inFlight.Done when finished.
+func (a *App) track() bool {
+	a.shutdownMu.RLock()
+	defer a.shutdownMu.RUnlock()
+
+	if a.closed {
+		return false
+	}
+	a.inFlight.Add(1)
+	return true
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin.
-func (a *App) CheckHealth(_ context.Context, _ *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+// It reports whether the astrolabe server can be reached with the current settings.
+func (a *App) CheckHealth(ctx context.Context, _ *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	if a.settingsErr != nil {
+		return healthError(healthDetails{}, a.settingsErr.Error()), nil
+	}
+	a.waitReady(ctx)
+
+	details := healthDetails{IndexerURL: redactURL(a.settings.indexerURL)}
+	if path, ok := unixSocketPath(a.settings.indexerURL.Host); ok {
+		conn, err := (&net.Dialer{Timeout: healthzTimeout}).DialContext(ctx, "unix", path)
+		if err != nil {
+			return healthError(details, fmt.Sprintf("Failed to connect to astrolabe server socket %s: %v", path, err)), nil
+		}
+		conn.Close()
+	}
+
+	target, err := a.settings.resolve(a.settings.indexerURL, a.settings.apiPath("namespaces", ""))
+	if err != nil {
+		return healthError(details, fmt.Sprintf("Failed to create health check request: %v", err)), nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return healthError(details, fmt.Sprintf("Failed to create health check request: %v", err)), nil
+	}
+	a.setUpstreamHeaders(req)
+	a.authorize(req)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return healthError(details, fmt.Sprintf("Failed to connect to astrolabe server: %v", err)), nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return healthError(details, fmt.Sprintf("Astrolabe server returned %s", resp.Status)), nil
+	}
+
+	// Older state servers have no version endpoint
+	if details.Version, err = a.fetchVersion(ctx); err != nil {
+		log.DefaultLogger.Debug("State server version unavailable", "error", err)
+	}
 	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusOk,
-		Message: "ok",
+		Status:      backend.HealthStatusOk,
+		Message:     "ok",
+		JSONDetails: details.marshal(),
 	}, nil
 }
+
+// healthDetails are the JSONDetails of a health check, shown on the config
+// page.
+type healthDetails struct {
+	IndexerURL string `json:"indexerUrl,omitempty"`
+	Version    string `json:"version,omitempty"`
+	LastError  string `json:"lastError,omitempty"`
+}
+
+func (d healthDetails) marshal() []byte {
+	data, err := json.Marshal(d)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to encode health details", "error", err)
+	}
+	return data
+}
+
+// redactURL returns the state server URL to report, without credentials. For
+// Unix domain sockets it is the socket URL as configured.
+func redactURL(u *url.URL) string {
+	if path, ok := unixSocketPath(u.Host); ok {
+		return "unix://" + path
+	}
+	redacted := *u
+	redacted.User = nil
+	return redacted.String()
+}
+
+// healthError builds a failed health check result, reporting msg as the last
+// error in the details.
+func healthError(details healthDetails, msg string) *backend.CheckHealthResult {
+	details.LastError = msg
+	return &backend.CheckHealthResult{
+		Status:      backend.HealthStatusError,
+		Message:     msg,
+		JSONDetails: details.marshal(),
+	}
+}
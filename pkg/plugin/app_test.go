@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// TestCheckHealth checks that the health status follows the upstream response.
+func TestCheckHealth(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		upstreamStatus int
+		expStatus      backend.HealthStatus
+		expDetails     healthDetails
+	}{
+		{name: "upstream ok", upstreamStatus: http.StatusOK, expStatus: backend.HealthStatusOk, expDetails: healthDetails{Version: "1.4.0"}},
+		{name: "upstream error", upstreamStatus: http.StatusInternalServerError, expStatus: backend.HealthStatusError, expDetails: healthDetails{LastError: "Astrolabe server returned 500 Internal Server Error"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotPath string
+			indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/api/v1/version" {
+					_, _ = w.Write([]byte(`{"version":"1.4.0"}`))
+					return
+				}
+				gotPath = r.URL.Path
+				w.WriteHeader(tc.upstreamStatus)
+			}))
+			defer indexer.Close()
+
+			// Credentials are redacted from the details
+			indexerURL := strings.Replace(indexer.URL, "http://", "http://grafana:s3cret@", 1)
+			app := newTestApp(t, `{"indexerUrl":"`+indexerURL+`"}`)
+			res, err := app.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+			if err != nil {
+				t.Fatalf("CheckHealth error: %s", err)
+			}
+			if res.Status != tc.expStatus {
+				t.Errorf("health status should be %s, got %s (%s)", tc.expStatus, res.Status, res.Message)
+			}
+			if gotPath != "/api/v1/namespaces" {
+				t.Errorf("health check should query /api/v1/namespaces, got %q", gotPath)
+			}
+
+			var details healthDetails
+			if err := json.Unmarshal(res.JSONDetails, &details); err != nil {
+				t.Fatalf("details should be JSON: %s", err)
+			}
+			tc.expDetails.IndexerURL = indexer.URL + "/"
+			if details != tc.expDetails {
+				t.Errorf("details should be %+v, got %+v", tc.expDetails, details)
+			}
+		})
+	}
+}
+
+// TestCheckHealthCustomCA checks that the configured CA is used to verify the
+// state server and that an unparsable bundle fails the health check.
+func TestCheckHealthCustomCA(t *testing.T) {
+	indexer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer indexer.Close()
+
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: indexer.Certificate().Raw}))
+
+	for _, tc := range []struct {
+		name      string
+		secure    map[string]string
+		expStatus backend.HealthStatus
+	}{
+		{name: "system roots", expStatus: backend.HealthStatusError},
+		{name: "custom CA", secure: map[string]string{"indexerCaCert": caPEM}, expStatus: backend.HealthStatusOk},
+		{name: "invalid CA", secure: map[string]string{"indexerCaCert": "not a certificate"}, expStatus: backend.HealthStatusError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inst, err := NewApp(context.Background(), backend.AppInstanceSettings{
+				JSONData:                []byte(`{"indexerUrl":"` + indexer.URL + `"}`),
+				DecryptedSecureJSONData: tc.secure,
+			})
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			res, err := inst.(*App).CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+			if err != nil {
+				t.Fatalf("CheckHealth error: %s", err)
+			}
+			if res.Status != tc.expStatus {
+				t.Errorf("health status should be %s, got %s (%s)", tc.expStatus, res.Status, res.Message)
+			}
+		})
+	}
+}
+
+// TestDispose checks that Dispose refuses new requests and waits for in-flight
+// ones to complete.
+func TestDispose(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-release
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+
+	inFlight := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		app.proxyToIndexer(inFlight, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+		close(served)
+	}()
+	<-received
+
+	disposed := make(chan struct{})
+	go func() {
+		app.Dispose()
+		close(disposed)
+	}()
+
+	// Wait for Dispose to stop accepting requests
+	for {
+		app.shutdownMu.RLock()
+		closed := app.closed
+		app.shutdownMu.RUnlock()
+		if closed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status after Dispose should be %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	select {
+	case <-disposed:
+		t.Fatal("Dispose should wait for the in-flight request")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-disposed
+	<-served
+	if inFlight.Code != http.StatusOK || inFlight.Body.String() != `{}` {
+		t.Errorf("in-flight request should complete, got %d %q", inFlight.Code, inFlight.Body.String())
+	}
+}
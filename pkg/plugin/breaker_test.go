@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestCircuitBreakerTransitions drives the breaker through all its states.
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	step := func(name string, expAllow bool, expState circuitState) {
+		t.Helper()
+		if allow := b.allow(); allow != expAllow {
+			t.Errorf("%s: allow should be %t, got %t", name, expAllow, allow)
+		}
+		if b.state != expState {
+			t.Errorf("%s: state should be %d, got %d", name, expState, b.state)
+		}
+	}
+
+	step("closed", true, circuitClosed)
+	b.record(false)
+	step("one failure", true, circuitClosed)
+	b.record(false)
+	step("threshold reached", false, circuitOpen)
+
+	now = now.Add(time.Minute)
+	step("cooldown passed", true, circuitHalfOpen)
+	step("probe in flight", false, circuitHalfOpen)
+	b.record(false)
+	step("probe failed", false, circuitOpen)
+
+	now = now.Add(time.Minute)
+	step("second probe", true, circuitHalfOpen)
+	b.record(true)
+	step("probe succeeded", true, circuitClosed)
+}
+
+// TestProxyCircuitBreaker checks that an open circuit fails fast with 503
+// without calling the state server.
+func TestProxyCircuitBreaker(t *testing.T) {
+	calls := 0
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxRetries":0,"circuitBreakerThreshold":2}`)
+
+	for i, expStatus := range []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		rec := httptest.NewRecorder()
+		app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+		if rec.Code != expStatus {
+			t.Errorf("request %d: status should be %d, got %d", i, expStatus, rec.Code)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("state server should be called twice, got %d", calls)
+	}
+}
@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// cachedEndpoints lists the endpoints whose GET responses may be cached.
+// They change rarely but are fetched on every dashboard load.
+var cachedEndpoints = map[string]bool{
+	"namespaces": true,
+	"releases":   true,
+}
+
+// isConditional reports whether a client request carries its own validators.
+func isConditional(req *http.Request) bool {
+	return req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+}
+
+// cacheEntry is a cached upstream response.
+type cacheEntry struct {
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// revalidatable reports whether the upstream gave validators for the entry,
+// allowing a conditional request once it has expired.
+func (e cacheEntry) revalidatable() bool {
+	return e.header.Get("ETag") != "" || e.header.Get("Last-Modified") != ""
+}
+
+// setConditional adds the entry's validators to an upstream request.
+func (e cacheEntry) setConditional(req *http.Request) {
+	if etag := e.header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := e.header.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// responseCache is an in-memory cache of upstream responses with a fixed TTL.
+// Expired entries are dropped unless they can be revalidated, in which case
+// they are kept until the upstream reports a change.
+type responseCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// get returns the entry for key and whether it is still fresh.
+func (c *responseCache) get(key string) (entry cacheEntry, fresh, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok = c.entries[key]
+	if !ok {
+		return cacheEntry{}, false, false
+	}
+	if time.Now().After(entry.expires) {
+		if !entry.revalidatable() {
+			delete(c.entries, key)
+			return cacheEntry{}, false, false
+		}
+		return entry, false, true
+	}
+	return entry, true, true
+}
+
+// set stores a response under key and drops any expired entries that cannot
+// be revalidated.
+func (c *responseCache) set(key string, header http.Header, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) && !entry.revalidatable() {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cacheEntry{
+		header:  header,
+		body:    body,
+		expires: now.Add(c.ttl),
+	}
+}
+
+// renew marks the entry for key as fresh again after the upstream confirmed
+// it is unchanged.
+func (c *responseCache) renew(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok {
+		entry.expires = time.Now().Add(c.ttl)
+		c.entries[key] = entry
+	}
+}
+
+// replace turns a 304 upstream response into the cached one.
+func (e cacheEntry) replace(resp *http.Response) {
+	resp.Body.Close()
+	resp.StatusCode = http.StatusOK
+	resp.Status = http.StatusText(http.StatusOK)
+	resp.Header = e.header.Clone()
+	resp.Header.Set("X-Cache", "REVALIDATED")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(e.body)))
+	resp.ContentLength = int64(len(e.body))
+	resp.Body = io.NopCloser(bytes.NewReader(e.body))
+}
+
+// serve writes a cached entry to the client.
+func (e cacheEntry) serve(w http.ResponseWriter) {
+	for key, values := range e.header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("X-Cache", "HIT")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(e.body)
+}
+
+// cachingBody records a response body as it is read and hands the complete
+// body to store once the upstream has been read to the end.
+type cachingBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	store func(body []byte)
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF && b.store != nil {
+		b.store(b.buf.Bytes())
+		b.store = nil
+	}
+	return n, err
+}
@@ -0,0 +1,212 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// TestProxyCache checks that only successful list and graph responses are
+// cached.
+func TestProxyCache(t *testing.T) {
+	calls := map[string]int{}
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.Path]++
+		if r.URL.Query().Get("namespace") == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`["web"]`))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","cacheTtlSeconds":60,"maxRetries":0}`)
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		endpoint string
+		upstream string
+		expCache []string
+		expCalls int
+	}{
+		{name: "releases cached", path: "/releases?namespace=default", endpoint: "releases", upstream: "/api/v1/releases", expCache: []string{"MISS", "HIT"}, expCalls: 1},
+		{name: "errors not cached", path: "/releases?namespace=missing", endpoint: "releases", upstream: "/api/v1/releases", expCache: []string{"MISS", "MISS"}, expCalls: 3},
+		{name: "graph cached", path: "/graph", endpoint: "graph", upstream: "/api/v1/graph", expCache: []string{"MISS", "HIT"}, expCalls: 1},
+		{name: "workloads not cached", path: "/workloads", endpoint: "workloads", upstream: "/api/v1/workloads", expCache: []string{"", ""}, expCalls: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for i, expCache := range tc.expCache {
+				rec := httptest.NewRecorder()
+				app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, tc.path, nil), tc.endpoint, "")
+
+				if got := rec.Header().Get("X-Cache"); got != expCache {
+					t.Errorf("request %d: X-Cache should be %q, got %q", i, expCache, got)
+				}
+				if rec.Code == http.StatusOK && rec.Body.String() != `["web"]` {
+					t.Errorf("request %d: unexpected body %q", i, rec.Body.String())
+				}
+			}
+			if calls[tc.upstream] != tc.expCalls {
+				t.Errorf("upstream %s should be called %d times in total, got %d", tc.upstream, tc.expCalls, calls[tc.upstream])
+			}
+		})
+	}
+}
+
+// TestProxyCacheRevalidation checks that expired entries with validators are
+// revalidated upstream and served from the cache on 304.
+func TestProxyCacheRevalidation(t *testing.T) {
+	etag := `"v1"`
+	var gotIfNoneMatch []string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotIfNoneMatch = append(gotIfNoneMatch, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte(`[` + etag + `]`))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","cacheTtlSeconds":60}`)
+	expire := func() {
+		app.cache.mu.Lock()
+		defer app.cache.mu.Unlock()
+		for key, entry := range app.cache.entries {
+			entry.expires = time.Now().Add(-time.Second)
+			app.cache.entries[key] = entry
+		}
+	}
+
+	for i, tc := range []struct {
+		expire    bool
+		newETag   string
+		expCache  string
+		expBody   string
+		expUpdate string
+	}{
+		{expCache: "MISS", expBody: `["v1"]`},
+		{expCache: "HIT", expBody: `["v1"]`},
+		{expire: true, expCache: "REVALIDATED", expBody: `["v1"]`, expUpdate: `"v1"`},
+		{expCache: "HIT", expBody: `["v1"]`},
+		{expire: true, newETag: `"v2"`, expCache: "MISS", expBody: `["v2"]`, expUpdate: `"v1"`},
+		{expCache: "HIT", expBody: `["v2"]`},
+	} {
+		if tc.expire {
+			expire()
+		}
+		if tc.newETag != "" {
+			etag = tc.newETag
+		}
+		gotIfNoneMatch = nil
+
+		rec := httptest.NewRecorder()
+		app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/namespaces", nil), "namespaces", "")
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("request %d: status should be %d, got %d", i, http.StatusOK, rec.Code)
+		}
+		if got := rec.Header().Get("X-Cache"); got != tc.expCache {
+			t.Errorf("request %d: X-Cache should be %q, got %q", i, tc.expCache, got)
+		}
+		if rec.Body.String() != tc.expBody {
+			t.Errorf("request %d: body should be %q, got %q", i, tc.expBody, rec.Body.String())
+		}
+		if tc.expUpdate != "" && (len(gotIfNoneMatch) != 1 || gotIfNoneMatch[0] != tc.expUpdate) {
+			t.Errorf("request %d: If-None-Match should be %q, got %q", i, tc.expUpdate, gotIfNoneMatch)
+		}
+	}
+}
+
+// TestProxyCacheOrgIsolation checks that cached lists are kept per org, so the
+// view the state server filtered for one org is not served to another.
+func TestProxyCacheOrgIsolation(t *testing.T) {
+	calls := map[string]int{}
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		org := r.Header.Get(grafanaOrgIDHeader)
+		calls[org]++
+		w.Header().Set("Content-Type", "application/json")
+		if org == "2" {
+			_, _ = w.Write([]byte(`["team-a"]`))
+			return
+		}
+		_, _ = w.Write([]byte(`["default","team-a","team-b"]`))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","cacheTtlSeconds":60,"orgNamespaceAllowlist":{"2":["team-a"]}}`)
+
+	for i, tc := range []struct {
+		orgID    int64
+		expCache string
+		expBody  string
+	}{
+		{orgID: 1, expCache: "MISS", expBody: `["default","team-a","team-b"]`},
+		{orgID: 2, expCache: "MISS", expBody: `["team-a"]`},
+		{orgID: 1, expCache: "HIT", expBody: `["default","team-a","team-b"]`},
+		{orgID: 2, expCache: "HIT", expBody: `["team-a"]`},
+	} {
+		ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: tc.orgID})
+		rec := httptest.NewRecorder()
+		app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/namespaces", nil).WithContext(ctx), "namespaces", "")
+
+		if got := rec.Header().Get("X-Cache"); got != tc.expCache {
+			t.Errorf("request %d: X-Cache should be %q, got %q", i, tc.expCache, got)
+		}
+		if rec.Body.String() != tc.expBody {
+			t.Errorf("request %d: body should be %s, got %s", i, tc.expBody, rec.Body.String())
+		}
+	}
+	if calls["1"] != 1 || calls["2"] != 1 {
+		t.Errorf("each org should reach the state server once, got %v", calls)
+	}
+}
+
+// TestProxyCacheUserIsolation checks that cached lists are kept per user, so
+// the view the state server filtered for one user is not served to another
+// of the same org.
+func TestProxyCacheUserIsolation(t *testing.T) {
+	calls := map[string]int{}
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get(grafanaUserHeader)
+		calls[user]++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`["` + user + `"]`))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","cacheTtlSeconds":60}`)
+
+	for i, tc := range []struct {
+		login    string
+		expCache string
+	}{
+		{login: "alice", expCache: "MISS"},
+		{login: "bob", expCache: "MISS"},
+		{login: "alice", expCache: "HIT"},
+		{login: "bob", expCache: "HIT"},
+	} {
+		user := &backend.User{Login: tc.login}
+		ctx := backend.WithUser(backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: 1, User: user}), user)
+		rec := httptest.NewRecorder()
+		app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/namespaces", nil).WithContext(ctx), "namespaces", "")
+
+		if got := rec.Header().Get("X-Cache"); got != tc.expCache {
+			t.Errorf("request %d: X-Cache should be %q, got %q", i, tc.expCache, got)
+		}
+		if exp := `["` + tc.login + `"]`; rec.Body.String() != exp {
+			t.Errorf("request %d: body should be %s, got %s", i, exp, rec.Body.String())
+		}
+	}
+	if calls["alice"] != 1 || calls["bob"] != 1 {
+		t.Errorf("each user should reach the state server once, got %v", calls)
+	}
+}
@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// streamedResources are the resource paths whose potentially large responses
+// are sent to Grafana in chunks as they are proxied instead of in one piece.
+var streamedResources = map[string]bool{
+	"graph":     true,
+	"resources": true,
+}
+
+// streamChunkBytes is the size of the body chunks sent for streamedResources.
+const streamChunkBytes = 64 << 10
+
+// CallResource serves a resource call with the routes of registerRoutes. The
+// responses of streamedResources are sent through the sender chunk by chunk,
+// every other one is sent whole by the SDK's httpadapter.
+func (a *App) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	path := "/" + strings.Trim(req.Path, "/")
+	if a.settings != nil {
+		path = strings.TrimPrefix(path, a.settings.RoutePrefix)
+	}
+	if !streamedResources[strings.Trim(path, "/")] {
+		return a.resources.CallResource(ctx, req, sender)
+	}
+
+	httpReq, err := newResourceRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+	w := &chunkedResponseWriter{sender: sender, header: http.Header{}}
+	a.mux.ServeHTTP(w, httpReq)
+	return w.close()
+}
+
+// newResourceRequest converts a resource call into the request served by the
+// mux, the same way httpadapter does.
+func newResourceRequest(ctx context.Context, req *backend.CallResourceRequest) (*http.Request, error) {
+	reqURL, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	target := "/" + strings.TrimPrefix(req.Path, "/")
+	if reqURL.RawQuery != "" {
+		target += "?" + reqURL.RawQuery
+	}
+
+	var body io.Reader
+	if len(req.Body) > 0 {
+		body = bytes.NewReader(req.Body)
+	}
+	ctx = backend.WithPluginContext(ctx, req.PluginContext)
+	ctx = backend.WithUser(ctx, req.PluginContext.User)
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range req.Headers {
+		httpReq.Header[key] = values
+	}
+	return httpReq, nil
+}
+
+// chunkedResponseWriter sends a response through a CallResourceResponseSender
+// in chunks of streamChunkBytes. The first message carries the status and
+// headers, the following ones only body.
+type chunkedResponseWriter struct {
+	sender backend.CallResourceResponseSender
+	header http.Header
+	status int
+	buf    bytes.Buffer
+
+	// sent is set once the first message went out, err if sending failed.
+	// Later writes are discarded after a failure.
+	sent bool
+	err  error
+}
+
+func (w *chunkedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *chunkedResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *chunkedResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		if w.header.Get("Content-Type") == "" {
+			w.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	// Large writes, such as the replay of a shared response, are split up
+	for written := 0; written < len(p); {
+		n := min(len(p)-written, streamChunkBytes-w.buf.Len())
+		w.buf.Write(p[written : written+n])
+		written += n
+		if w.buf.Len() >= streamChunkBytes {
+			if w.Flush(); w.err != nil {
+				return written, w.err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends what was written so far, e.g. every event of a stream.
+func (w *chunkedResponseWriter) Flush() {
+	if w.err != nil || (w.sent && w.buf.Len() == 0) {
+		return
+	}
+	resp := &backend.CallResourceResponse{Body: bytes.Clone(w.buf.Bytes())}
+	if !w.sent {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		resp.Status = w.status
+		resp.Headers = w.header.Clone()
+	}
+	w.buf.Reset()
+	w.sent = true
+	w.err = w.sender.Send(resp)
+}
+
+// close sends the rest of the response, making sure the status and headers
+// are sent even if nothing was written.
+func (w *chunkedResponseWriter) close() error {
+	w.Flush()
+	return w.err
+}
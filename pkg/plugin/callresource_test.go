@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// collectingSender records every response sent to it.
+type collectingSender struct {
+	responses []*backend.CallResourceResponse
+}
+
+func (s *collectingSender) Send(response *backend.CallResourceResponse) error {
+	s.responses = append(s.responses, response)
+	return nil
+}
+
+// TestCallResourceStreaming checks that the large endpoints are sent in
+// chunks while the others are sent whole.
+func TestCallResourceStreaming(t *testing.T) {
+	graph := `{"nodes":[` + strings.Repeat(`{"uid":"pod","kind":"Pod","name":"x"},`, 5000) + `{"uid":"svc","kind":"Service","name":"x"}],"edges":[]}`
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/graph":
+			_, _ = w.Write([]byte(graph))
+		default:
+			_, _ = w.Write([]byte(`["default"]`))
+		}
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+
+	for _, tc := range []struct {
+		path      string
+		expBody   string
+		expChunks func(int) bool
+	}{
+		{path: "graph", expBody: graph, expChunks: func(n int) bool { return n > 1 }},
+		{path: "namespaces", expBody: `["default"]`, expChunks: func(n int) bool { return n == 1 }},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			var sender collectingSender
+			err := app.CallResource(context.Background(), &backend.CallResourceRequest{
+				Method: http.MethodGet,
+				Path:   tc.path,
+				URL:    tc.path,
+			}, &sender)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if !tc.expChunks(len(sender.responses)) {
+				t.Fatalf("unexpected number of responses sent: %d", len(sender.responses))
+			}
+
+			first := sender.responses[0]
+			if first.Status != http.StatusOK {
+				t.Errorf("response status should be %d, got %d", http.StatusOK, first.Status)
+			}
+			if ct := first.Headers["Content-Type"]; len(ct) == 0 || ct[0] != "application/json" {
+				t.Errorf("Content-Type should be application/json, got %v", ct)
+			}
+			var body bytes.Buffer
+			for i, resp := range sender.responses {
+				if i > 0 && (resp.Status != 0 || resp.Headers != nil) {
+					t.Errorf("chunk %d should only carry body", i)
+				}
+				body.Write(resp.Body)
+			}
+			if body.String() != tc.expBody {
+				t.Errorf("body should be the upstream body, got %d bytes", body.Len())
+			}
+		})
+	}
+}
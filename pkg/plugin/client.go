@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// healthzTimeout bounds health probes independently of the configured
+// request timeout so a slow cluster does not make the health page hang.
+const healthzTimeout = 5 * time.Second
+
+// newIndexerClient builds the HTTP client shared by all handlers talking to
+// the state server. A single transport is used so connections are pooled
+// across requests.
+func newIndexerClient(settings *appSettings) (*http.Client, error) {
+	transport, err := newIndexerTransport(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &userAgentTransport{next: transport, userAgent: settings.UserAgent},
+		Timeout:   settings.requestTimeout(),
+	}, nil
+}
+
+// userAgentTransport sets the User-Agent of every upstream request so plugin
+// traffic can be told apart in the state server's logs.
+type userAgentTransport struct {
+	next      *http.Transport
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
+
+// CloseIdleConnections lets the client close the pooled connections on
+// Dispose.
+func (t *userAgentTransport) CloseIdleConnections() {
+	t.next.CloseIdleConnections()
+}
+
+// newIndexerTransport builds the pooled transport used for upstream calls.
+func newIndexerTransport(settings *appSettings) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy: proxyFromEnvironment,
+		DialContext: dialUnixSockets((&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext),
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          settings.MaxIdleConns,
+		MaxIdleConnsPerHost:   settings.MaxIdleConnsPerHost,
+		IdleConnTimeout:       time.Duration(settings.IdleConnTimeoutMs) * time.Millisecond,
+		TLSHandshakeTimeout:   time.Duration(settings.TLSHandshakeTimeoutMs) * time.Millisecond,
+		ResponseHeaderTimeout: time.Duration(settings.ResponseHeaderTimeoutMs) * time.Millisecond,
+		ExpectContinueTimeout: 1 * time.Second,
+		// Compression is negotiated by proxyToIndexer so that gzip bodies can
+		// be relayed as is to clients that accept them.
+		DisableCompression: true,
+		// A custom TLS config turns off HTTP/2 unless asked for explicitly.
+		// https state servers negotiate it and fall back to HTTP/1.1.
+		ForceAttemptHTTP2: true,
+	}
+	if settings.EnableH2C {
+		// Unencrypted HTTP/2 is only used when HTTP/1 is disabled, so the
+		// state server must speak HTTP/2 on every configured URL.
+		protocols := new(http.Protocols)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		transport.Protocols = protocols
+	}
+	return transport, nil
+}
+
+// newTLSConfig builds the TLS configuration for an https state server. A
+// configured CA bundle replaces the system roots; it is an error if it does
+// not contain any certificate.
+func newTLSConfig(settings *appSettings) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: settings.IndexerInsecureSkipVerify, // explicitly opted into by the operator
+	}
+	if settings.IndexerCACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(settings.IndexerCACert)) {
+			return nil, errors.New("invalid indexer CA certificate: no PEM certificates found")
+		}
+		config.RootCAs = pool
+	}
+
+	// Client certificates for mutual TLS must be configured as a pair
+	cert, key := settings.IndexerClientCert, settings.IndexerClientKey
+	if (cert == "") != (key == "") {
+		return nil, errors.New("invalid indexer client certificate: both certificate and key must be set")
+	}
+	if cert != "" {
+		pair, err := tls.X509KeyPair([]byte(cert), []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid indexer client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
@@ -0,0 +1,224 @@
+package plugin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// newClientCert generates a self-signed client certificate and returns it
+// along with its key, both PEM encoded.
+func newClientCert(t *testing.T) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "astrolabe-grafana"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return cert, string(certPEM), string(keyPEM)
+}
+
+// TestProxyMutualTLS checks that the configured client certificate is
+// presented to a state server requiring one.
+func TestProxyMutualTLS(t *testing.T) {
+	clientCert, certPEM, keyPEM := newClientCert(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	indexer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	indexer.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	indexer.StartTLS()
+	defer indexer.Close()
+
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: indexer.Certificate().Raw}))
+
+	for _, tc := range []struct {
+		name      string
+		secure    map[string]string
+		expStatus int
+		expHealth backend.HealthStatus
+	}{
+		{
+			name:      "valid pair",
+			secure:    map[string]string{"indexerCaCert": caPEM, "indexerClientCert": certPEM, "indexerClientKey": keyPEM},
+			expStatus: http.StatusOK,
+			expHealth: backend.HealthStatusOk,
+		},
+		{
+			name:      "no client certificate",
+			secure:    map[string]string{"indexerCaCert": caPEM},
+			expStatus: http.StatusBadGateway,
+			expHealth: backend.HealthStatusError,
+		},
+		{
+			name:      "certificate without key",
+			secure:    map[string]string{"indexerCaCert": caPEM, "indexerClientCert": certPEM},
+			expStatus: http.StatusInternalServerError,
+			expHealth: backend.HealthStatusError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inst, err := NewApp(context.Background(), backend.AppInstanceSettings{
+				JSONData:                []byte(`{"indexerUrl":"` + indexer.URL + `","maxRetries":0}`),
+				DecryptedSecureJSONData: tc.secure,
+			})
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+
+			res, err := app.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+			if err != nil {
+				t.Fatalf("CheckHealth error: %s", err)
+			}
+			if res.Status != tc.expHealth {
+				t.Errorf("health status should be %s, got %s (%s)", tc.expHealth, res.Status, res.Message)
+			}
+		})
+	}
+}
+
+// TestProxyHTTP2 checks that many concurrent requests are served over HTTP/2
+// for https state servers and, with enableH2C, for plaintext ones.
+func TestProxyHTTP2(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		tls  bool
+		h2c  bool
+	}{
+		{name: "https", tls: true},
+		{name: "h2c", h2c: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			protos := map[string]int{}
+			indexer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				protos[r.Proto]++
+				mu.Unlock()
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			indexer.Config.Protocols = new(http.Protocols)
+			indexer.Config.Protocols.SetHTTP1(true)
+			indexer.Config.Protocols.SetHTTP2(true)
+			indexer.Config.Protocols.SetUnencryptedHTTP2(true)
+
+			secure := map[string]string{}
+			if tc.tls {
+				indexer.EnableHTTP2 = true
+				indexer.StartTLS()
+				secure["indexerCaCert"] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: indexer.Certificate().Raw}))
+			} else {
+				indexer.Start()
+			}
+			defer indexer.Close()
+
+			inst, err := NewApp(context.Background(), backend.AppInstanceSettings{
+				JSONData:                []byte(`{"indexerUrl":"` + indexer.URL + `","enableH2C":` + strconv.FormatBool(tc.h2c) + `}`),
+				DecryptedSecureJSONData: secure,
+			})
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			app := inst.(*App)
+
+			const requests = 50
+			var wg sync.WaitGroup
+			for i := range requests {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					// Distinct queries so the requests are not deduplicated
+					rec := httptest.NewRecorder()
+					app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph?namespace=ns-"+strconv.Itoa(i), nil), "graph", "")
+					if rec.Code != http.StatusOK {
+						t.Errorf("response status should be %d, got %d", http.StatusOK, rec.Code)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if protos["HTTP/2.0"] != requests {
+				t.Errorf("all %d requests should use HTTP/2, got %v", requests, protos)
+			}
+		})
+	}
+}
+
+// TestUpstreamUserAgent checks that upstream requests carry the plugin's
+// User-Agent rather than the client's.
+func TestUpstreamUserAgent(t *testing.T) {
+	var gotUserAgent string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserAgent = r.UserAgent()
+	}))
+	defer indexer.Close()
+
+	for _, tc := range []struct {
+		name         string
+		jsonData     string
+		expUserAgent string
+	}{
+		{name: "default", jsonData: `{"indexerUrl":"` + indexer.URL + `"}`, expUserAgent: "astrolabe-grafana/dev"},
+		{name: "override", jsonData: `{"indexerUrl":"` + indexer.URL + `","userAgent":"dashboards/1.0"}`, expUserAgent: "dashboards/1.0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t, tc.jsonData)
+			req := httptest.NewRequest(http.MethodGet, "/graph", nil)
+			req.Header.Set("User-Agent", "Mozilla/5.0")
+			app.proxyToIndexer(httptest.NewRecorder(), req, "graph", "")
+			if gotUserAgent != tc.expUserAgent {
+				t.Errorf("proxied User-Agent should be %q, got %q", tc.expUserAgent, gotUserAgent)
+			}
+
+			gotUserAgent = ""
+			if _, err := app.CheckHealth(context.Background(), &backend.CheckHealthRequest{}); err != nil {
+				t.Fatalf("CheckHealth error: %s", err)
+			}
+			if gotUserAgent != tc.expUserAgent {
+				t.Errorf("health check User-Agent should be %q, got %q", tc.expUserAgent, gotUserAgent)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(header http.Header) bool {
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+			if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+				continue
+			}
+			if qv, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if q, err := strconv.ParseFloat(qv, 64); err == nil && q == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// errInvalidUpstreamResponse marks upstream responses that could not be relayed.
+var errInvalidUpstreamResponse = errors.New("invalid response from astrolabe server")
+
+// decompressResponse replaces the body of a gzip-encoded upstream response
+// with its decompressed stream and fixes up the headers to match.
+func decompressResponse(resp *http.Response) error {
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidUpstreamResponse, err)
+	}
+	resp.Body = &gzipBody{Reader: gz, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return nil
+}
+
+// gzipBody reads the decompressed stream and closes the underlying body.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	_ = b.Reader.Close()
+	return b.body.Close()
+}
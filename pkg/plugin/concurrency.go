@@ -0,0 +1,114 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errTooManyUpstream is returned when no upstream slot became free in time.
+var errTooManyUpstream = errors.New("too many concurrent requests to astrolabe server")
+
+// upstreamQueueTimeout is how long a request waits for one of the concurrent
+// upstream slots before it is rejected.
+const upstreamQueueTimeout = 500 * time.Millisecond
+
+// concurrencyLimiter is a semaphore capping the number of upstream requests
+// in flight. A nil limiter is unlimited.
+type concurrencyLimiter struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+func newConcurrencyLimiter(limit int) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		slots: make(chan struct{}, limit),
+		wait:  upstreamQueueTimeout,
+	}
+}
+
+// acquire takes a slot, waiting up to l.wait for one to be released. It
+// reports false if none became free in time or ctx was cancelled.
+func (l *concurrencyLimiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot taken by acquire.
+func (l *concurrencyLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// unlimitedKey marks the context of requests exempt from the concurrency
+// limit, see withoutUpstreamSlot.
+type unlimitedKey struct{}
+
+// withoutUpstreamSlot exempts the requests made with ctx from the concurrency
+// limit. Event streams are, since they would hold on to a slot for as long as
+// the client listens.
+func withoutUpstreamSlot(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unlimitedKey{}, true)
+}
+
+// concurrencyTransport takes one of the limited upstream slots for every
+// request, from the call until its response body is closed, so the limit
+// applies to the proxied requests and the plugin's own calls alike. Retries
+// and redirects of a request are made within its slot.
+type concurrencyTransport struct {
+	next  http.RoundTripper
+	slots *concurrencyLimiter
+}
+
+func (t *concurrencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Context().Value(unlimitedKey{}) != nil {
+		return t.next.RoundTrip(req)
+	}
+	if !t.slots.acquire(req.Context()) {
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		return nil, errTooManyUpstream
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.slots.release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: t.slots.release}
+	return resp, nil
+}
+
+// releasingBody releases an upstream slot once the response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
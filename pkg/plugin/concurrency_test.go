@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestProxyConcurrencyLimit checks that no more than maxConcurrentUpstream
+// requests reach the state server at once and that requests finding no free
+// slot are rejected with 503.
+func TestProxyConcurrencyLimit(t *testing.T) {
+	var inFlight, maxInFlight, calls atomic.Int32
+	received := make(chan struct{}, 3)
+	unblock := make(chan struct{})
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			if m := maxInFlight.Load(); n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		received <- struct{}{}
+		<-unblock
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxConcurrentUpstream":2}`)
+	proxy := func(i int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		// Distinct queries so the requests don't share one upstream call
+		req := httptest.NewRequest(http.MethodGet, "/graph?namespace=ns-"+strconv.Itoa(i), nil)
+		app.proxyToIndexer(rec, req, "graph", "")
+		return rec
+	}
+
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, 2)
+	for i := range recs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recs[i] = proxy(i)
+		}()
+	}
+	<-received
+	<-received
+
+	if rec := proxy(2); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("request beyond the limit should get 503, got %d", rec.Code)
+	}
+
+	close(unblock)
+	wg.Wait()
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK {
+			t.Errorf("request %d should get 200, got %d", i, rec.Code)
+		}
+	}
+	if rec := proxy(3); rec.Code != http.StatusOK {
+		t.Errorf("request after the slots were released should get 200, got %d", rec.Code)
+	}
+	if got := maxInFlight.Load(); got != 2 {
+		t.Errorf("at most 2 requests should be in flight upstream, got %d", got)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("upstream should be called 3 times, got %d", got)
+	}
+}
+
+// TestConcurrencyLimitCoversFetches checks that the plugin's own upstream
+// calls share the slots of the proxied requests, and that event streams don't
+// take one.
+func TestConcurrencyLimitCoversFetches(t *testing.T) {
+	received := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/graph/stream":
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("data: {}\n\n"))
+		case "/api/v1/graph":
+			received <- struct{}{}
+			<-unblock
+		}
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxConcurrentUpstream":1}`)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.proxyToIndexer(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+	}()
+	<-received
+
+	target, _ := url.Parse(indexer.URL + "/api/v1/namespaces")
+	if _, _, err := app.fetch(context.Background(), "namespaces", target); !errors.Is(err, errTooManyUpstream) {
+		t.Errorf("fetch beyond the limit should fail with %v, got %v", errTooManyUpstream, err)
+	}
+	rec := httptest.NewRecorder()
+	app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph/stream", nil), "graph_stream", "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("stream should not need a slot, got %d", rec.Code)
+	}
+
+	close(unblock)
+	<-done
+	if _, _, err := app.fetch(context.Background(), "namespaces", target); err != nil {
+		t.Errorf("fetch after the slot was released should succeed, got %v", err)
+	}
+}
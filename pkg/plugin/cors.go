@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"net/http"
+	"slices"
+)
+
+// CORS headers sent to allowed origins. Only the read methods of the proxy
+// routes are allowed, and the headers the UI reads are exposed.
+const (
+	corsAllowMethods  = "GET, HEAD, OPTIONS"
+	corsAllowHeaders  = "Accept, Accept-Encoding, Content-Type, X-Request-ID"
+	corsExposeHeaders = "X-Cache, X-Continue-Token, X-Request-ID, X-Upstream-Duration-Ms"
+)
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if the origin is not configured. "*" only matches when configured
+// explicitly.
+func (s *appSettings) allowedOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	if slices.Contains(s.AllowedOrigins, origin) {
+		return origin
+	}
+	if slices.Contains(s.AllowedOrigins, "*") {
+		return "*"
+	}
+	return ""
+}
+
+// cors adds CORS headers for configured origins and answers preflight
+// requests, which never reach the wrapped handler.
+func (a *App) cors(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var allowOrigin string
+		if a.settings != nil {
+			allowOrigin = a.settings.allowedOrigin(req.Header.Get("Origin"))
+		}
+		w.Header().Add("Vary", "Origin")
+		if allowOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		}
+
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			if allowOrigin != "" {
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h(w, req)
+	}
+}
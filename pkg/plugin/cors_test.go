@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer indexer.Close()
+
+	for _, tc := range []struct {
+		name           string
+		allowedOrigins string
+		method         string
+		origin         string
+		expStatus      int
+		expOrigin      string
+		expMethods     string
+	}{
+		{name: "allowed origin", allowedOrigins: `["https://portal.example.com"]`, method: http.MethodGet, origin: "https://portal.example.com", expStatus: http.StatusOK, expOrigin: "https://portal.example.com"},
+		{name: "other origin", allowedOrigins: `["https://portal.example.com"]`, method: http.MethodGet, origin: "https://evil.example.com", expStatus: http.StatusOK},
+		{name: "no origins configured", allowedOrigins: `[]`, method: http.MethodGet, origin: "https://portal.example.com", expStatus: http.StatusOK},
+		{name: "wildcard", allowedOrigins: `["*"]`, method: http.MethodGet, origin: "https://portal.example.com", expStatus: http.StatusOK, expOrigin: "*"},
+		{name: "preflight", allowedOrigins: `["https://portal.example.com"]`, method: http.MethodOptions, origin: "https://portal.example.com", expStatus: http.StatusNoContent, expOrigin: "https://portal.example.com", expMethods: corsAllowMethods},
+		{name: "preflight from other origin", allowedOrigins: `["https://portal.example.com"]`, method: http.MethodOptions, origin: "https://evil.example.com", expStatus: http.StatusNoContent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","allowedOrigins":`+tc.allowedOrigins+`}`)
+			mux := http.NewServeMux()
+			app.registerRoutes(mux)
+
+			req := httptest.NewRequest(tc.method, "/graph", nil)
+			req.Header.Set("Origin", tc.origin)
+			if tc.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tc.expOrigin {
+				t.Errorf("Access-Control-Allow-Origin should be %q, got %q", tc.expOrigin, got)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); got != tc.expMethods {
+				t.Errorf("Access-Control-Allow-Methods should be %q, got %q", tc.expMethods, got)
+			}
+		})
+	}
+}
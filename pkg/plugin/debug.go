@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// redacted is shown in place of secrets that are set.
+const redacted = "***"
+
+// debugSettings is the JSON view of the effective settings served by
+// /debug/settings. Values read from secureJsonData are only reported as set
+// or not, and the state server URLs are shown without credentials.
+type debugSettings struct {
+	*appSettings
+
+	IndexerURL string            `json:"indexerUrl"`
+	Clusters   map[string]string `json:"clusters"`
+
+	IndexerToken      string `json:"indexerToken"`
+	IndexerUsername   string `json:"indexerUsername"`
+	IndexerPassword   string `json:"indexerPassword"`
+	IndexerClientCert string `json:"indexerClientCert"`
+	IndexerClientKey  string `json:"indexerClientKey"`
+
+	// Routes are the patterns of the routes that are enabled.
+	Routes []string `json:"routes"`
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// handleDebugSettings returns the settings the backend is running with. It is
+// only available when enableDebugEndpoints is set.
+func (a *App) handleDebugSettings(w http.ResponseWriter, req *http.Request) {
+	if a.settings == nil || !a.settings.EnableDebugEndpoints {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	clusters := make(map[string]string, len(a.settings.clusterURLs))
+	for name, u := range a.settings.clusterURLs {
+		clusters[name] = redactURL(u)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(debugSettings{
+		appSettings:       a.settings,
+		IndexerURL:        redactURL(a.settings.indexerURL),
+		Clusters:          clusters,
+		IndexerToken:      redact(a.settings.IndexerToken),
+		IndexerUsername:   redact(a.settings.IndexerUsername),
+		IndexerPassword:   redact(a.settings.IndexerPassword),
+		IndexerClientCert: redact(a.settings.IndexerClientCert),
+		IndexerClientKey:  redact(a.settings.IndexerClientKey),
+		Routes:            a.routes,
+	})
+	if err != nil {
+		log.DefaultLogger.Error("Failed to write settings", "error", err)
+	}
+}
@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// dedupKey identifies identical GET requests that may share one upstream
+// call. Besides the target, the response depends on the encoding and media
+// type asked for, on whether the plugin filters it and, as far as the state
+// server is concerned, on the identity headers forwarded to it.
+func dedupKey(req *http.Request, target *url.URL, gzip, filtered bool) string {
+	pluginCtx := backend.PluginConfigFromContext(req.Context())
+	var login string
+	if pluginCtx.User != nil {
+		login = pluginCtx.User.Login
+	}
+	return fmt.Sprintf("%s gzip=%t filtered=%t accept=%q org=%d user=%s",
+		target, gzip, filtered, req.Header.Get("Accept"), pluginCtx.OrgID, login)
+}
+
+// sharedResponse buffers a proxied response so it can be written to every
+// request waiting on the same upstream call.
+type sharedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+
+	// cancelled is set when the client that made the call went away, which
+	// aborted the upstream call for everyone.
+	cancelled bool
+}
+
+func newSharedResponse() *sharedResponse {
+	return &sharedResponse{header: http.Header{}, status: http.StatusOK}
+}
+
+func (r *sharedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *sharedResponse) Write(p []byte) (int, error) {
+	return r.body.Write(p)
+}
+
+func (r *sharedResponse) WriteHeader(status int) {
+	r.status = status
+}
+
+// Flush is a no-op; the response is written out once complete.
+func (r *sharedResponse) Flush() {}
+
+// replay writes a copy of the response to w. The buffered response itself is
+// never modified so it is safe to replay concurrently. A non-empty requestID
+// replaces the request ID echoed by the state server, which is that of the
+// request that made the call.
+func (r *sharedResponse) replay(w http.ResponseWriter, requestID string) {
+	for key, values := range r.header {
+		w.Header()[key] = append([]string(nil), values...)
+	}
+	if requestID != "" && w.Header().Get(requestIDHeader) != "" {
+		w.Header().Set(requestIDHeader, requestID)
+	}
+	w.WriteHeader(r.status)
+	_, _ = w.Write(r.body.Bytes())
+}
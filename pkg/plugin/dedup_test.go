@@ -0,0 +1,150 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestProxyDeduplication checks that concurrent identical GET requests share
+// one upstream call while other methods do not.
+func TestProxyDeduplication(t *testing.T) {
+	var calls atomic.Int32
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// Give the other requests time to join the in-flight call
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"nodes":[]}`))
+	}))
+	defer indexer.Close()
+
+	for _, tc := range []struct {
+		method   string
+		expCalls int32
+		expBody  string
+	}{
+		{method: http.MethodGet, expCalls: 1, expBody: `{"nodes":[]}`},
+		{method: http.MethodHead, expCalls: 10},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			calls.Store(0)
+			app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+
+			var wg sync.WaitGroup
+			for range 10 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rec := httptest.NewRecorder()
+					app.proxyToIndexer(rec, httptest.NewRequest(tc.method, "/graph", nil), "graph", "")
+
+					if rec.Code != http.StatusOK || rec.Body.String() != tc.expBody {
+						t.Errorf("response should be 200 %q, got %d %q", tc.expBody, rec.Code, rec.Body.String())
+					}
+					if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+						t.Errorf("Content-Type should be application/json, got %q", ct)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if got := calls.Load(); got != tc.expCalls {
+				t.Errorf("upstream should be called %d times, got %d", tc.expCalls, got)
+			}
+		})
+	}
+}
+
+// TestProxyDeduplicationCancelledLeader checks that requests sharing a call
+// make their own when the client that made it goes away.
+func TestProxyDeduplicationCancelledLeader(t *testing.T) {
+	var calls atomic.Int32
+	received := make(chan struct{}, 2)
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			received <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxRetries":0}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		app.proxyToIndexer(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/graph", nil).WithContext(ctx), "graph", "")
+		close(leaderDone)
+	}()
+	<-received
+
+	follower := httptest.NewRecorder()
+	followerDone := make(chan struct{})
+	go func() {
+		app.proxyToIndexer(follower, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+		close(followerDone)
+	}()
+	// Let the follower join the in-flight call before it is cancelled
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-leaderDone
+	<-followerDone
+
+	if follower.Code != http.StatusOK || follower.Body.String() != `{}` {
+		t.Errorf("follower should get its own response, got %d %q", follower.Code, follower.Body.String())
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("upstream should be called twice, got %d", got)
+	}
+}
+
+// TestProxyDeduplicationPerRequest checks that requests for another media type
+// don't share a call and that every request sharing one gets its own request
+// ID back.
+func TestProxyDeduplicationPerRequest(t *testing.T) {
+	var calls atomic.Int32
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// Give the other requests time to join the in-flight call
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set(requestIDHeader, r.Header.Get(requestIDHeader))
+		_, _ = w.Write([]byte(r.Header.Get("Accept")))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+
+	var wg sync.WaitGroup
+	for i, accept := range []string{"application/json", "application/json", "application/yaml", "application/yaml"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			requestID := fmt.Sprintf("request-%d", i)
+			req := httptest.NewRequest(http.MethodGet, "/graph", nil)
+			req.Header.Set("Accept", accept)
+			req.Header.Set(requestIDHeader, requestID)
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, req, "graph", "")
+
+			if rec.Body.String() != accept {
+				t.Errorf("body should be %q, got %q", accept, rec.Body.String())
+			}
+			if got := rec.Header().Get(requestIDHeader); got != requestID {
+				t.Errorf("%s should be %q, got %q", requestIDHeader, requestID, got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("upstream should be called twice, got %d", got)
+	}
+}
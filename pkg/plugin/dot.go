@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// dotContentType is the media type of Graphviz DOT.
+const dotContentType = "text/vnd.graphviz"
+
+// acceptsDOT reports whether the Accept header asks for Graphviz DOT.
+func acceptsDOT(header http.Header) bool {
+	for _, value := range header.Values("Accept") {
+		for _, mediaRange := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil || mediaType != dotContentType {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// dotEscaper escapes text for a quoted DOT string.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// toDOT renders a state server graph as a Graphviz digraph. Nodes are labeled
+// with their name and kind, edges with their type.
+func toDOT(body []byte) ([]byte, error) {
+	var graph struct {
+		Nodes []struct {
+			UID  string `json:"uid"`
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"nodes"`
+		Edges []struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+			Type string `json:"type"`
+		} `json:"edges"`
+	}
+	if err := json.Unmarshal(body, &graph); err != nil {
+		return nil, fmt.Errorf("graph is not an object with nodes and edges: %w", err)
+	}
+
+	var dot bytes.Buffer
+	dot.WriteString("digraph astrolabe {\n")
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(&dot, "  \"%s\" [label=\"%s\\n%s\"];\n",
+			dotEscaper.Replace(node.UID), dotEscaper.Replace(node.Name), dotEscaper.Replace(node.Kind))
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&dot, "  \"%s\" -> \"%s\" [label=\"%s\"];\n",
+			dotEscaper.Replace(edge.From), dotEscaper.Replace(edge.To), dotEscaper.Replace(edge.Type))
+	}
+	dot.WriteString("}\n")
+	return dot.Bytes(), nil
+}
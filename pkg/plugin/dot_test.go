@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHandleGraphDOT checks the rendering of the graph as DOT, requested with
+// the format param or the Accept header.
+func TestHandleGraphDOT(t *testing.T) {
+	var gotAccept string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = r.Header.Get("Accept")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(sampleGraph))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	if err := app.registerRoutes(mux); err != nil {
+		t.Fatal(err)
+	}
+
+	const expDOT = "digraph astrolabe {\n" +
+		"  \"d1\" [label=\"web\\nDeployment\"];\n" +
+		"  \"p1\" [label=\"web-abc\\nPod\"];\n" +
+		"  \"d1\" -> \"p1\" [label=\"owns\"];\n" +
+		"}\n"
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		accept string
+		expDOT bool
+	}{
+		{name: "format param", path: "/graph?format=dot", expDOT: true},
+		{name: "accept header", path: "/graph", accept: "text/vnd.graphviz, application/json;q=0.5", expDOT: true},
+		{name: "refused by accept header", path: "/graph", accept: "text/vnd.graphviz;q=0"},
+		{name: "json by default", path: "/graph", accept: "application/json"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("response status should be %d, got %d", http.StatusOK, rec.Code)
+			}
+			if !tc.expDOT {
+				if rec.Body.String() != sampleGraph {
+					t.Errorf("graph should be passed through unchanged, got %q", rec.Body.String())
+				}
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != dotContentType {
+				t.Errorf("Content-Type should be %s, got %q", dotContentType, ct)
+			}
+			if rec.Body.String() != expDOT {
+				t.Errorf("body should be\n%s\ngot\n%s", expDOT, rec.Body.String())
+			}
+			if gotAccept != "application/json" {
+				t.Errorf("upstream Accept should be application/json, got %q", gotAccept)
+			}
+		})
+	}
+}
+
+func TestToDOTEscapes(t *testing.T) {
+	got, err := toDOT([]byte(`{"nodes":[{"uid":"a\"b","kind":"Pod","name":"x\\y"}],"edges":[]}`))
+	if err != nil {
+		t.Fatalf("toDOT error: %s", err)
+	}
+	exp := "digraph astrolabe {\n  \"a\\\"b\" [label=\"x\\\\y\\nPod\"];\n}\n"
+	if string(got) != exp {
+		t.Errorf("DOT should be\n%s\ngot\n%s", exp, got)
+	}
+}
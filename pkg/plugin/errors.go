@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// errorResponse is the JSON body returned for every error produced by the plugin.
+type errorResponse struct {
+	Error string `json:"error"`
+
+	// Code identifies failures to reach the state server, see
+	// writeUpstreamFailure.
+	Code string `json:"code,omitempty"`
+
+	// Status and Detail are set when relaying an upstream error.
+	Status int    `json:"status,omitempty"`
+	Detail string `json:"detail,omitempty"`
+
+	// Indexer is the state server that could not be reached, without
+	// credentials.
+	Indexer string `json:"indexer,omitempty"`
+
+	// Path is the unknown resource path of a 404.
+	Path string `json:"path,omitempty"`
+}
+
+// writeJSONError writes msg as a JSON error body with the given status code.
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(errorResponse{Error: msg}); err != nil {
+		log.DefaultLogger.Error("Failed to write error response", "error", err)
+	}
+}
+
+// handleNotFound answers requests to paths no route is registered for.
+func handleNotFound(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	if err := json.NewEncoder(w).Encode(errorResponse{Error: "not found", Path: req.URL.Path}); err != nil {
+		log.DefaultLogger.Error("Failed to write error response", "error", err)
+	}
+}
+
+// writeBodyTooLarge rejects a request whose body exceeds limit bytes.
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+	writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", limit))
+}
+
+// Codes of the upstream failures, telling a state server that is down from
+// one that is slow.
+const (
+	codeUpstreamUnreachable = "upstream_unreachable"
+	codeUpstreamTimeout     = "upstream_timeout"
+)
+
+// writeUpstreamUnreachable reports that the state server at indexerURL could
+// not be reached, e.g. because the connection was refused.
+func writeUpstreamUnreachable(w http.ResponseWriter, indexerURL *url.URL, err error) {
+	writeUpstreamFailure(w, http.StatusBadGateway, codeUpstreamUnreachable, "upstream unreachable", indexerURL, err)
+}
+
+// writeUpstreamTimeout reports that the state server at indexerURL did not
+// respond in time.
+func writeUpstreamTimeout(w http.ResponseWriter, indexerURL *url.URL, err error) {
+	writeUpstreamFailure(w, http.StatusGatewayTimeout, codeUpstreamTimeout, "astrolabe server did not respond in time", indexerURL, err)
+}
+
+// writeUpstreamFailure writes the error of a failed upstream call. Credentials
+// embedded in the URL are left out of the response, including the error
+// detail.
+func writeUpstreamFailure(w http.ResponseWriter, status int, code, msg string, indexerURL *url.URL, err error) {
+	indexer := *indexerURL
+	detail := err.Error()
+	if indexer.User != nil {
+		detail = strings.ReplaceAll(detail, indexer.User.String()+"@", "")
+		indexer.User = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(errorResponse{
+		Error:   msg,
+		Code:    code,
+		Detail:  detail,
+		Indexer: indexer.String(),
+	}); err != nil {
+		log.DefaultLogger.Error("Failed to write error response", "error", err)
+	}
+}
+
+// maxErrorDetailBytes bounds how much of a non-JSON upstream error body is
+// kept as detail.
+const maxErrorDetailBytes = 512
+
+// isJSONContentType reports whether a Content-Type header denotes JSON.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// wrapNonJSONError replaces the body of an upstream error response that is
+// not JSON, such as an ingress HTML error page, with our JSON error shape.
+// The upstream status code is kept.
+func wrapNonJSONError(resp *http.Response) error {
+	if resp.StatusCode < http.StatusBadRequest || isJSONContentType(resp.Header.Get("Content-Type")) {
+		return nil
+	}
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		if err := decompressResponse(resp); err != nil {
+			return err
+		}
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorDetailBytes))
+	resp.Body.Close()
+
+	body, err := json.Marshal(errorResponse{
+		Error:  fmt.Sprintf("astrolabe server returned %s", resp.Status),
+		Status: resp.StatusCode,
+		Detail: strings.TrimSpace(string(detail)),
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
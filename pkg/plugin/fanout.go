@@ -0,0 +1,157 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	// allClusters is the cluster param asking for the graph of every cluster.
+	allClusters = "all"
+
+	// defaultCluster names the primary state server in merged graphs.
+	defaultCluster = "default"
+)
+
+// clusterWarning reports a cluster left out of a merged graph.
+type clusterWarning struct {
+	Cluster string `json:"cluster"`
+	Error   string `json:"error"`
+}
+
+// mergedGraph is the graph of several clusters. Node UIDs, and so the edge
+// ends, are prefixed with the cluster name and nodes carry a cluster field.
+type mergedGraph struct {
+	Nodes    []map[string]any `json:"nodes"`
+	Edges    []map[string]any `json:"edges"`
+	Warnings []clusterWarning `json:"warnings,omitempty"`
+}
+
+// proxyGraph proxies the graph of the cluster selected by the cluster param,
+// or that of every cluster with cluster=all.
+func (a *App) proxyGraph(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Query().Get("cluster") == allClusters {
+		a.serveAllClusters(w, req)
+		return
+	}
+	a.proxyToIndexer(w, req, "graph", "")
+}
+
+// serveAllClusters fetches the graph of the primary state server and of every
+// configured cluster concurrently and merges them. Each is proxied as if asked
+// for with its own cluster param, so it is cached and limited as usual. The
+// clusters that fail are reported in warnings; only if all fail is the
+// response a 502.
+func (a *App) serveAllClusters(w http.ResponseWriter, req *http.Request) {
+	if a.settingsErr != nil {
+		writeJSONError(w, http.StatusInternalServerError, a.settingsErr.Error())
+		return
+	}
+	if req.URL.Query().Get("pretty") == "true" {
+		pretty := newRewriteWriter(w, indentJSON)
+		defer pretty.finish()
+		w = pretty
+	}
+
+	// A cluster named like the primary one replaces it, as it does for the
+	// cluster param
+	clusters := map[string]string{defaultCluster: ""}
+	for name := range a.settings.clusterURLs {
+		clusters[name] = name
+	}
+	names := slices.Sorted(maps.Keys(clusters))
+
+	var (
+		graphs = make([]*sharedResponse, len(names))
+		wg     sync.WaitGroup
+	)
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			graphs[i] = newSharedResponse()
+			a.proxyToIndexer(graphs[i], clusterRequest(req, clusters[name]), "graph", "")
+		}()
+	}
+	wg.Wait()
+
+	result := mergedGraph{Nodes: []map[string]any{}, Edges: []map[string]any{}}
+	for i, name := range names {
+		if err := result.add(name, graphs[i]); err != nil {
+			log.DefaultLogger.Warn("Cluster graph failed", "cluster", name, "error", err)
+			result.Warnings = append(result.Warnings, clusterWarning{Cluster: name, Error: err.Error()})
+		}
+	}
+
+	status := http.StatusOK
+	if len(result.Warnings) == len(names) {
+		status = http.StatusBadGateway
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.DefaultLogger.Error("Failed to write merged graph", "error", err)
+	}
+}
+
+// clusterRequest returns a copy of req asking for the uncompressed JSON graph
+// of the cluster, or of the primary state server if cluster is empty.
+// Conditional headers are dropped since only a full graph can be merged.
+func clusterRequest(req *http.Request, cluster string) *http.Request {
+	sub := req.Clone(req.Context())
+	query := sub.URL.Query()
+	query.Del("pretty")
+	query.Del("cluster")
+	if cluster != "" {
+		query.Set("cluster", cluster)
+	}
+	sub.URL.RawQuery = query.Encode()
+	for _, key := range []string{"Accept-Encoding", "If-None-Match", "If-Modified-Since"} {
+		sub.Header.Del(key)
+	}
+	sub.Header.Set("Accept", "application/json")
+	return sub
+}
+
+// add merges the graph response of a cluster, or returns why it can't.
+func (g *mergedGraph) add(cluster string, resp *sharedResponse) error {
+	if resp.status != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(resp.body.Bytes(), &body); err == nil && body.Error != "" {
+			return fmt.Errorf("status %d: %s", resp.status, body.Error)
+		}
+		return fmt.Errorf("status %d", resp.status)
+	}
+	var graph struct {
+		Nodes []map[string]any `json:"nodes"`
+		Edges []map[string]any `json:"edges"`
+	}
+	if err := json.Unmarshal(resp.body.Bytes(), &graph); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidUpstreamResponse, err)
+	}
+
+	prefix := func(element map[string]any, key string) {
+		if id, ok := element[key].(string); ok {
+			element[key] = cluster + "/" + id
+		}
+	}
+	for _, node := range graph.Nodes {
+		prefix(node, "uid")
+		node["cluster"] = cluster
+		g.Nodes = append(g.Nodes, node)
+	}
+	for _, edge := range graph.Edges {
+		prefix(edge, "from")
+		prefix(edge, "to")
+		g.Edges = append(g.Edges, edge)
+	}
+	return nil
+}
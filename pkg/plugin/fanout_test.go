@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestServeAllClusters checks that cluster=all merges the graphs of every
+// cluster and reports the ones that failed as warnings.
+func TestServeAllClusters(t *testing.T) {
+	newIndexer := func(status int, body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Has("cluster") {
+				t.Error("cluster should not be forwarded upstream")
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		}))
+	}
+	graph := `{"nodes":[{"uid":"a","kind":"Pod"},{"uid":"b","kind":"Service"}],"edges":[{"from":"b","to":"a","type":"selects"}]}`
+	primary, east := newIndexer(http.StatusOK, graph), newIndexer(http.StatusOK, graph)
+	west := newIndexer(http.StatusInternalServerError, `{"error":"index not ready"}`)
+	defer primary.Close()
+	defer east.Close()
+	defer west.Close()
+
+	for _, tc := range []struct {
+		name        string
+		clusters    string
+		expStatus   int
+		expNodes    []string
+		expEdges    [][2]string
+		expWarnings []string
+	}{
+		{
+			name:        "partial",
+			clusters:    `{"east":"` + east.URL + `","west":"` + west.URL + `"}`,
+			expStatus:   http.StatusOK,
+			expNodes:    []string{"default/a", "default/b", "east/a", "east/b"},
+			expEdges:    [][2]string{{"default/b", "default/a"}, {"east/b", "east/a"}},
+			expWarnings: []string{"west"},
+		},
+		{
+			name:        "all failed",
+			clusters:    `{"default":"` + west.URL + `"}`,
+			expStatus:   http.StatusBadGateway,
+			expWarnings: []string{"default"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t, `{"indexerUrl":"`+primary.URL+`","clusters":`+tc.clusters+`}`)
+			rec := httptest.NewRecorder()
+			app.handleGraph(rec, httptest.NewRequest(http.MethodGet, "/graph?cluster=all", nil))
+
+			if rec.Code != tc.expStatus {
+				t.Fatalf("status should be %d, got %d: %s", tc.expStatus, rec.Code, rec.Body)
+			}
+			var merged mergedGraph
+			if err := json.Unmarshal(rec.Body.Bytes(), &merged); err != nil {
+				t.Fatalf("response should be a merged graph: %s", err)
+			}
+			if len(merged.Nodes) != len(tc.expNodes) {
+				t.Fatalf("nodes should be %v, got %v", tc.expNodes, merged.Nodes)
+			}
+			for i, node := range merged.Nodes {
+				if node["uid"] != tc.expNodes[i] {
+					t.Errorf("node %d should be %s, got %v", i, tc.expNodes[i], node["uid"])
+				}
+				if node["cluster"] == nil {
+					t.Errorf("node %d should have a cluster", i)
+				}
+			}
+			if len(merged.Edges) != len(tc.expEdges) {
+				t.Fatalf("edges should be %v, got %v", tc.expEdges, merged.Edges)
+			}
+			for i, edge := range merged.Edges {
+				if edge["from"] != tc.expEdges[i][0] || edge["to"] != tc.expEdges[i][1] {
+					t.Errorf("edge %d should be %v, got %v -> %v", i, tc.expEdges[i], edge["from"], edge["to"])
+				}
+			}
+			if len(merged.Warnings) != len(tc.expWarnings) {
+				t.Fatalf("warnings should be for %v, got %v", tc.expWarnings, merged.Warnings)
+			}
+			for i, warning := range merged.Warnings {
+				if warning.Cluster != tc.expWarnings[i] || warning.Error == "" {
+					t.Errorf("warning %d should be for %s, got %+v", i, tc.expWarnings[i], warning)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// graphCacheIdle is how long a graph query stays active without being
+// requested. Active queries are refreshed in the background.
+const graphCacheIdle = 5 * time.Minute
+
+// graphIdentity is the Grafana user and org a response was fetched for. The
+// state server may tailor graphs and lists to the identity headers, so they
+// are cached per identity and graphs are refreshed on its behalf. The
+// forwarded cookies are sent again with refreshes but are not part of the
+// key, since they belong to the same user.
+type graphIdentity struct {
+	orgID  int64
+	user   *backend.User
+	cookie string
+}
+
+// identityFromContext returns the identity setIdentityHeaders forwards for
+// requests made with ctx.
+func identityFromContext(ctx context.Context) graphIdentity {
+	cookie, _ := ctx.Value(cookiesKey{}).(string)
+	return graphIdentity{
+		orgID:  backend.PluginConfigFromContext(ctx).OrgID,
+		user:   backend.UserFromContext(ctx),
+		cookie: cookie,
+	}
+}
+
+// key is the cache key of the response of target for the identity.
+func (id graphIdentity) key(target *url.URL) string {
+	var login string
+	if id.user != nil {
+		login = id.user.Login
+	}
+	return fmt.Sprintf("%s org=%d user=%s", target, id.orgID, login)
+}
+
+// context returns a copy of parent carrying the identity.
+func (id graphIdentity) context(parent context.Context) context.Context {
+	ctx := backend.WithPluginContext(parent, backend.PluginContext{OrgID: id.orgID, User: id.user})
+	if id.cookie != "" {
+		ctx = context.WithValue(ctx, cookiesKey{}, id.cookie)
+	}
+	return backend.WithUser(ctx, id.user)
+}
+
+// graphEntry is a cached graph response along with its refresh state.
+type graphEntry struct {
+	target     *url.URL
+	identity   graphIdentity
+	header     http.Header
+	body       []byte
+	fetched    time.Time
+	lastUsed   time.Time
+	refreshing bool
+}
+
+// graphCache keeps the graphs of recently requested queries warm. Requests
+// are always served from the cache once a query has been fetched: stale
+// entries are served while a refresh runs in the background, and run
+// refreshes all active queries every ttl.
+type graphCache struct {
+	ttl   time.Duration
+	idle  time.Duration
+	fetch func(ctx context.Context, target *url.URL) (http.Header, []byte, error)
+	now   func() time.Time
+
+	// ctx bounds background refreshes and is cancelled on Dispose.
+	ctx context.Context
+
+	mu      sync.Mutex
+	entries map[string]*graphEntry
+}
+
+func newGraphCache(ctx context.Context, ttl time.Duration, fetch func(context.Context, *url.URL) (http.Header, []byte, error)) *graphCache {
+	return &graphCache{
+		ttl:     ttl,
+		idle:    graphCacheIdle,
+		fetch:   fetch,
+		now:     time.Now,
+		ctx:     ctx,
+		entries: make(map[string]*graphEntry),
+	}
+}
+
+// get returns the graph of target cached for the identity along with its age.
+// A stale entry is still returned but triggers a refresh unless one is in
+// flight.
+func (c *graphCache) get(target *url.URL, identity graphIdentity) (graphEntry, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := identity.key(target)
+	entry, ok := c.entries[key]
+	if !ok {
+		return graphEntry{}, 0, false
+	}
+	now := c.now()
+	entry.lastUsed = now
+	age := now.Sub(entry.fetched)
+	if age >= c.ttl && !entry.refreshing {
+		entry.refreshing = true
+		go c.refresh(key)
+	}
+	return *entry, age, true
+}
+
+// set stores a graph of target fetched for the identity.
+func (c *graphCache) set(target *url.URL, identity graphIdentity, header http.Header, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.entries[identity.key(target)] = &graphEntry{
+		target:   target,
+		identity: identity,
+		header:   header,
+		body:     body,
+		fetched:  now,
+		lastUsed: now,
+	}
+}
+
+// refresh fetches the graph for key again, on behalf of the identity it was
+// first fetched for. On failure the stale entry is
+// kept and retried on the next request or tick.
+func (c *graphCache) refresh(key string) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	header, body, err := c.fetch(entry.identity.context(c.ctx), entry.target)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.refreshing = false
+	if err != nil {
+		log.DefaultLogger.Warn("Failed to refresh cached graph", "target", key, "error", err)
+		return
+	}
+	entry.header = header
+	entry.body = body
+	entry.fetched = c.now()
+}
+
+// refreshAll refreshes every active entry and drops the ones that have not
+// been requested for c.idle.
+func (c *graphCache) refreshAll() {
+	c.mu.Lock()
+	now := c.now()
+	var keys []string
+	for key, entry := range c.entries {
+		switch {
+		case now.Sub(entry.lastUsed) >= c.idle:
+			delete(c.entries, key)
+		case !entry.refreshing:
+			entry.refreshing = true
+			keys = append(keys, key)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, key := range keys {
+		c.refresh(key)
+	}
+}
+
+// run refreshes the active entries every ttl until the cache's context is
+// cancelled.
+func (c *graphCache) run() {
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.refreshAll()
+		}
+	}
+}
+
+// serve writes a cached graph to the client. Entries older than the TTL are
+// reported as STALE.
+func (e graphEntry) serve(w http.ResponseWriter, age, ttl time.Duration) {
+	for key, values := range e.header {
+		w.Header()[key] = values
+	}
+	status := "HIT"
+	if age >= ttl {
+		status = "STALE"
+	}
+	w.Header().Set("X-Cache", status)
+	w.Header().Set("X-Cache-Age", strconv.Itoa(int(age.Seconds())))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(e.body)
+}
+
+// fetchGraph fetches a graph from the state server for the graph cache,
+// uncompressed so it can be served to any client.
+func (a *App) fetchGraph(ctx context.Context, target *url.URL) (http.Header, []byte, error) {
+	header, body, err := a.fetch(ctx, "graph", target)
+	if err != nil {
+		return nil, nil, err
+	}
+	if a.settings.ValidateResponses {
+		if _, err := validateResponse("graph", responseSchemas["graph"])(body); err != nil {
+			return nil, nil, err
+		}
+	}
+	return header, body, nil
+}
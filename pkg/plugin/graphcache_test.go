@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// TestGraphCache checks that stale graphs are served while they are refreshed
+// and that idle queries are dropped.
+func TestGraphCache(t *testing.T) {
+	var mu sync.Mutex
+	version := 1
+	fail := false
+	fetch := func(ctx context.Context, target *url.URL) (http.Header, []byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			return nil, nil, errors.New("unavailable")
+		}
+		return http.Header{}, []byte(strconv.Itoa(version)), nil
+	}
+
+	now := time.Now()
+	c := newGraphCache(t.Context(), time.Minute, fetch)
+	c.now = func() time.Time { return now }
+	target, _ := url.Parse("http://astrolabe/api/v1/graph?namespace=default")
+
+	waitRefreshed := func() {
+		for {
+			c.mu.Lock()
+			refreshing := c.entries[graphIdentity{}.key(target)].refreshing
+			c.mu.Unlock()
+			if !refreshing {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	get := func(name string, expBody string, expAge time.Duration) {
+		t.Helper()
+		entry, age, ok := c.get(target, graphIdentity{})
+		if !ok {
+			t.Fatalf("%s: graph should be cached", name)
+		}
+		if string(entry.body) != expBody || age != expAge {
+			t.Errorf("%s: graph should be %s aged %s, got %s aged %s", name, expBody, expAge, entry.body, age)
+		}
+	}
+
+	if _, _, ok := c.get(target, graphIdentity{}); ok {
+		t.Fatal("graph should not be cached yet")
+	}
+	c.set(target, graphIdentity{}, http.Header{}, []byte("1"))
+	get("fresh", "1", 0)
+
+	// A stale graph is served as is while it is refreshed
+	mu.Lock()
+	version = 2
+	mu.Unlock()
+	now = now.Add(time.Minute)
+	get("stale", "1", time.Minute)
+	waitRefreshed()
+	get("refreshed", "2", 0)
+
+	// Failed refreshes keep the stale graph
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	c.refreshAll()
+	get("failed refresh", "2", 0)
+
+	// Queries that are not requested anymore are dropped
+	now = now.Add(graphCacheIdle)
+	c.refreshAll()
+	if _, _, ok := c.get(target, graphIdentity{}); ok {
+		t.Error("idle graph should be dropped")
+	}
+}
+
+// TestProxyGraphCache checks the cache headers of graphs served from the cache.
+func TestProxyGraphCache(t *testing.T) {
+	calls := 0
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"nodes":[],"edges":[]}`))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","cacheTtlSeconds":60}`)
+	defer app.Dispose()
+
+	for i, expCache := range []string{"MISS", "HIT", "HIT"} {
+		req := httptest.NewRequest(http.MethodGet, "/graph?namespace=default", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		app.proxyToIndexer(rec, req, "graph", "")
+
+		if got := rec.Header().Get("X-Cache"); got != expCache {
+			t.Errorf("request %d: X-Cache should be %q, got %q", i, expCache, got)
+		}
+		if expCache == "HIT" && rec.Header().Get("X-Cache-Age") != "0" {
+			t.Errorf("request %d: X-Cache-Age should be 0, got %q", i, rec.Header().Get("X-Cache-Age"))
+		}
+		if rec.Body.String() != `{"nodes":[],"edges":[]}` {
+			t.Errorf("request %d: unexpected body %q", i, rec.Body.String())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("upstream should be called once, got %d", calls)
+	}
+}
+
+// TestProxyGraphCacheIdentity checks that cached graphs are kept per org and
+// user, and that they are refreshed with the identity they were fetched for.
+func TestProxyGraphCacheIdentity(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := r.Header.Get(grafanaOrgIDHeader) + "/" + r.Header.Get(grafanaUserHeader)
+		mu.Lock()
+		calls[identity]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"nodes":[{"uid":"` + identity + `"}],"edges":[]}`))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","cacheTtlSeconds":60}`)
+	defer app.Dispose()
+
+	for i, tc := range []struct {
+		orgID    int64
+		login    string
+		expCache string
+	}{
+		{orgID: 1, login: "alice", expCache: "MISS"},
+		{orgID: 2, login: "alice", expCache: "MISS"},
+		{orgID: 2, login: "bob", expCache: "MISS"},
+		{orgID: 1, login: "alice", expCache: "HIT"},
+		{orgID: 2, login: "bob", expCache: "HIT"},
+	} {
+		user := &backend.User{Login: tc.login}
+		ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: tc.orgID, User: user})
+		ctx = backend.WithUser(ctx, user)
+		rec := httptest.NewRecorder()
+		app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph", nil).WithContext(ctx), "graph", "")
+
+		if got := rec.Header().Get("X-Cache"); got != tc.expCache {
+			t.Errorf("request %d: X-Cache should be %q, got %q", i, tc.expCache, got)
+		}
+		expBody := `{"nodes":[{"uid":"` + strconv.FormatInt(tc.orgID, 10) + "/" + tc.login + `"}],"edges":[]}`
+		if rec.Body.String() != expBody {
+			t.Errorf("request %d: body should be %s, got %s", i, expBody, rec.Body.String())
+		}
+	}
+
+	// Refreshes are made on behalf of each identity
+	app.graphCache.refreshAll()
+	mu.Lock()
+	defer mu.Unlock()
+	for _, identity := range []string{"1/alice", "2/alice", "2/bob"} {
+		if calls[identity] != 2 {
+			t.Errorf("%s should reach the state server twice, got %d", identity, calls[identity])
+		}
+	}
+	if len(calls) != 3 {
+		t.Errorf("only the requesting identities should reach the state server, got %v", calls)
+	}
+}
@@ -0,0 +1,214 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// graphDiff is the response of /graph/diff.
+type graphDiff struct {
+	From  string      `json:"from"`
+	To    string      `json:"to"`
+	Nodes elementDiff `json:"nodes"`
+	Edges elementDiff `json:"edges"`
+}
+
+// elementDiff lists the nodes or edges added, removed and changed between two
+// graph snapshots.
+type elementDiff struct {
+	Added   []json.RawMessage `json:"added"`
+	Removed []json.RawMessage `json:"removed"`
+	Changed []changedElement  `json:"changed"`
+}
+
+// changedElement is a node or edge present in both snapshots with different
+// contents.
+type changedElement struct {
+	Key  string          `json:"key"`
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+// handleGraphDiff compares the graph at the from and to timestamps, given in
+// RFC 3339. Both snapshots are fetched from the state server with the at
+// param, scoped by the namespace and release params like /graph. Nodes are
+// matched by UID and edges by their ends and type.
+func (a *App) handleGraphDiff(w http.ResponseWriter, req *http.Request) {
+	if !a.track() {
+		writeJSONError(w, http.StatusServiceUnavailable, "plugin is shutting down")
+		return
+	}
+	defer a.inFlight.Done()
+
+	if a.settingsErr != nil {
+		writeJSONError(w, http.StatusInternalServerError, a.settingsErr.Error())
+		return
+	}
+	timestamps := map[string]string{}
+	for _, param := range []string{"from", "to"} {
+		value := req.URL.Query().Get(param)
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("%s must be an RFC 3339 timestamp", param))
+			return
+		}
+		timestamps[param] = value
+	}
+	a.waitReady(req.Context())
+
+	indexerURL, err := a.getIndexerURL(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Malformed names are rejected like on /graph
+	query, _ := filterQuery("graph", req.URL.RawQuery)
+	values, _ := url.ParseQuery(query)
+	if err := validateQuery(values); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query = withDefaultNamespace("graph", query, a.settings.DefaultNamespace)
+
+	var (
+		snapshots = map[string][]byte{}
+		errs      = map[string]error{}
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+	)
+	for param, at := range timestamps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, err := a.fetchGraphSnapshot(req, indexerURL, query, at)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[param] = err
+				return
+			}
+			snapshots[param] = body
+		}()
+	}
+	wg.Wait()
+
+	for _, param := range []string{"from", "to"} {
+		if err := errs[param]; err != nil {
+			log.DefaultLogger.Warn("Graph snapshot unavailable", "at", timestamps[param], "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			_ = json.NewEncoder(w).Encode(errorResponse{
+				Error:  fmt.Sprintf("graph snapshot at %s is unavailable", timestamps[param]),
+				Detail: err.Error(),
+			})
+			return
+		}
+	}
+
+	diff, err := diffGraphs(snapshots["from"], snapshots["to"])
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("%v: %v", errInvalidUpstreamResponse, err))
+		return
+	}
+	diff.From, diff.To = timestamps["from"], timestamps["to"]
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(diff); err != nil {
+		log.DefaultLogger.Error("Failed to write graph diff response", "error", err)
+	}
+}
+
+// fetchGraphSnapshot fetches the graph as it was at the given time.
+func (a *App) fetchGraphSnapshot(req *http.Request, indexerURL *url.URL, query, at string) ([]byte, error) {
+	target, err := a.settings.resolve(indexerURL, a.settings.apiPath("graph", ""))
+	if err != nil {
+		return nil, err
+	}
+	params, _ := url.ParseQuery(query)
+	params.Set("at", at)
+	target.RawQuery = params.Encode()
+	_, body, err := a.fetch(req.Context(), "graph", target)
+	return body, err
+}
+
+// diffGraphs compares two graphs in the state server format.
+func diffGraphs(from, to []byte) (*graphDiff, error) {
+	fromNodes, fromEdges, err := graphElements(from)
+	if err != nil {
+		return nil, err
+	}
+	toNodes, toEdges, err := graphElements(to)
+	if err != nil {
+		return nil, err
+	}
+	return &graphDiff{
+		Nodes: diffElements(fromNodes, toNodes),
+		Edges: diffElements(fromEdges, toEdges),
+	}, nil
+}
+
+// graphElements returns the nodes of a graph keyed by UID, which every node
+// must have, and its edges keyed by their ends and type. The elements are
+// re-encoded so that equal ones compare equal regardless of key order and
+// whitespace.
+func graphElements(body []byte) (nodes, edges map[string]json.RawMessage, err error) {
+	var graph struct {
+		Nodes []map[string]any `json:"nodes"`
+		Edges []map[string]any `json:"edges"`
+	}
+	if err := json.Unmarshal(body, &graph); err != nil {
+		return nil, nil, fmt.Errorf("graph is not an object with nodes and edges: %w", err)
+	}
+	nodes = make(map[string]json.RawMessage, len(graph.Nodes))
+	for i, node := range graph.Nodes {
+		// Nodes can only be matched by a UID; without one they would all
+		// collapse into a single element
+		uid, ok := node["uid"].(string)
+		if !ok || uid == "" {
+			return nil, nil, fmt.Errorf("node %d has no uid", i)
+		}
+		if nodes[uid], err = json.Marshal(node); err != nil {
+			return nil, nil, err
+		}
+	}
+	edges = make(map[string]json.RawMessage, len(graph.Edges))
+	for _, edge := range graph.Edges {
+		key := fmt.Sprintf("%v->%v:%v", edge["from"], edge["to"], edge["type"])
+		if edges[key], err = json.Marshal(edge); err != nil {
+			return nil, nil, err
+		}
+	}
+	return nodes, edges, nil
+}
+
+// diffElements compares the keyed elements of two snapshots. The lists are
+// sorted by key so that the diff is stable.
+func diffElements(from, to map[string]json.RawMessage) elementDiff {
+	diff := elementDiff{
+		Added:   []json.RawMessage{},
+		Removed: []json.RawMessage{},
+		Changed: []changedElement{},
+	}
+	for _, key := range slices.Sorted(maps.Keys(to)) {
+		before, ok := from[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, to[key])
+		case !bytes.Equal(before, to[key]):
+			diff.Changed = append(diff.Changed, changedElement{Key: key, From: before, To: to[key]})
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(from)) {
+		if _, ok := to[key]; !ok {
+			diff.Removed = append(diff.Removed, from[key])
+		}
+	}
+	return diff
+}
@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestHandleGraphDiff checks the diff of two graph snapshots and the errors
+// for missing snapshots and invalid timestamps.
+func TestHandleGraphDiff(t *testing.T) {
+	snapshots := map[string]string{
+		"2026-01-01T00:00:00Z": `{"nodes":[{"uid":"a","name":"web","status":"Pending"},{"uid":"b","name":"db"}],` +
+			`"edges":[{"from":"a","to":"b","type":"selects"}]}`,
+		"2026-01-02T00:00:00Z": `{"nodes":[{"status":"Running","name":"web","uid":"a"},{"uid":"c","name":"cache"}],` +
+			`"edges":[{"from":"a","to":"c","type":"selects"}]}`,
+	}
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ns := r.URL.Query().Get("namespace"); ns != "default" {
+			t.Errorf("namespace should be default, got %q", ns)
+		}
+		snapshot, ok := snapshots[r.URL.Query().Get("at")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(snapshot))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxRetries":0}`)
+	mux := http.NewServeMux()
+	if err := app.registerRoutes(mux); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("diff", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/graph/diff?namespace=default&from=2026-01-01T00:00:00Z&to=2026-01-02T00:00:00Z", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("response status should be %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var diff graphDiff
+		if err := json.Unmarshal(rec.Body.Bytes(), &diff); err != nil {
+			t.Fatalf("response should be a graph diff: %s", err)
+		}
+		for _, tc := range []struct {
+			name string
+			got  int
+			exp  int
+		}{
+			{name: "added nodes", got: len(diff.Nodes.Added), exp: 1},
+			{name: "removed nodes", got: len(diff.Nodes.Removed), exp: 1},
+			{name: "changed nodes", got: len(diff.Nodes.Changed), exp: 1},
+			{name: "added edges", got: len(diff.Edges.Added), exp: 1},
+			{name: "removed edges", got: len(diff.Edges.Removed), exp: 1},
+			{name: "changed edges", got: len(diff.Edges.Changed), exp: 0},
+		} {
+			if tc.got != tc.exp {
+				t.Errorf("%s should be %d, got %d", tc.name, tc.exp, tc.got)
+			}
+		}
+		if len(diff.Nodes.Changed) == 1 && diff.Nodes.Changed[0].Key != "a" {
+			t.Errorf("changed node should be a, got %q", diff.Nodes.Changed[0].Key)
+		}
+		if len(diff.Nodes.Added) == 1 && !strings.Contains(string(diff.Nodes.Added[0]), `"uid":"c"`) {
+			t.Errorf("added node should be c, got %s", diff.Nodes.Added[0])
+		}
+	})
+
+	for _, tc := range []struct {
+		name      string
+		query     string
+		expStatus int
+		expError  string
+	}{
+		{name: "missing snapshot", query: "from=2026-01-01T00:00:00Z&to=2025-01-01T00:00:00Z", expStatus: http.StatusBadGateway, expError: "graph snapshot at 2025-01-01T00:00:00Z is unavailable"},
+		{name: "missing timestamp", query: "from=2026-01-01T00:00:00Z", expStatus: http.StatusBadRequest, expError: "to must be an RFC 3339 timestamp"},
+		{name: "invalid timestamp", query: "from=yesterday&to=2026-01-02T00:00:00Z", expStatus: http.StatusBadRequest, expError: "from must be an RFC 3339 timestamp"},
+		{name: "invalid release", query: "release=a%20b&from=2026-01-01T00:00:00Z&to=2026-01-02T00:00:00Z", expStatus: http.StatusBadRequest, expError: `invalid release "a b": must be ` + paramFormats["release"].desc + " of at most " + strconv.Itoa(paramFormats["release"].maxLen) + " characters"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/graph/diff?namespace=default&"+tc.query, nil))
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("response body should be a JSON error, got %q", rec.Body.String())
+			}
+			if body.Error != tc.expError {
+				t.Errorf("error should be %q, got %q", tc.expError, body.Error)
+			}
+		})
+	}
+}
+
+// TestDiffGraphsRequiresUIDs checks that nodes without a string UID are
+// rejected rather than collapsed into a single element.
+func TestDiffGraphsRequiresUIDs(t *testing.T) {
+	valid := `{"nodes":[{"uid":"a"}],"edges":[]}`
+	for _, tc := range []struct {
+		name string
+		to   string
+	}{
+		{name: "missing uid", to: `{"nodes":[{"uid":"a"},{"name":"web"},{"name":"db"}],"edges":[]}`},
+		{name: "empty uid", to: `{"nodes":[{"uid":""}],"edges":[]}`},
+		{name: "numeric uid", to: `{"nodes":[{"uid":1}],"edges":[]}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := diffGraphs([]byte(valid), []byte(tc.to)); err == nil {
+				t.Error("diff should fail")
+			}
+		})
+	}
+}
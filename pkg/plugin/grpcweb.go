@@ -0,0 +1,246 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protocols spoken to the state server, set by the protocol setting.
+const (
+	protocolREST    = "rest"
+	protocolGRPCWeb = "grpcweb"
+)
+
+const (
+	// grpcWebService is the gRPC service of the state server.
+	grpcWebService = "astrolabe.v1.StateService"
+
+	grpcWebContentType = "application/grpc-web+proto"
+
+	// grpcWebTrailerFlag marks the frame holding the trailers.
+	grpcWebTrailerFlag = 0x80
+)
+
+// grpcWebMethods maps the endpoints served over gRPC-Web to their method of
+// grpcWebService. The others are still proxied to the REST API.
+//
+// Both take a request whose field 1 holds the repeated namespace filter and
+// return a response whose field 1 holds the repeated names:
+//
+//	message ListNamespacesRequest {}
+//	message ListNamespacesResponse { repeated string namespaces = 1; }
+//	message ListReleasesRequest { repeated string namespace = 1; }
+//	message ListReleasesResponse { repeated string releases = 1; }
+var grpcWebMethods = map[string]string{
+	"namespaces": "ListNamespaces",
+	"releases":   "ListReleases",
+}
+
+// grpcWebStatuses maps the gRPC status codes the state server is expected to
+// return to the HTTP status relayed to the client. Other codes are a 502.
+var grpcWebStatuses = map[int]int{
+	3:  http.StatusBadRequest,         // INVALID_ARGUMENT
+	4:  http.StatusGatewayTimeout,     // DEADLINE_EXCEEDED
+	5:  http.StatusNotFound,           // NOT_FOUND
+	7:  http.StatusForbidden,          // PERMISSION_DENIED
+	8:  http.StatusTooManyRequests,    // RESOURCE_EXHAUSTED
+	12: http.StatusNotImplemented,     // UNIMPLEMENTED
+	14: http.StatusServiceUnavailable, // UNAVAILABLE
+	16: http.StatusUnauthorized,       // UNAUTHENTICATED
+}
+
+// grpcWebError is a non-OK gRPC status returned by the state server.
+type grpcWebError struct {
+	code    int
+	message string
+}
+
+func (e *grpcWebError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("astrolabe server returned gRPC status %d", e.code)
+	}
+	return fmt.Sprintf("astrolabe server returned gRPC status %d: %s", e.code, e.message)
+}
+
+// proxyGRPCWeb serves a REST endpoint listed in grpcWebMethods by calling its
+// gRPC-Web method, relaying the names returned as the same JSON array the
+// REST API responds with. query is the filtered query of the REST call.
+func (a *App) proxyGRPCWeb(w http.ResponseWriter, req *http.Request, endpoint string, indexerURL *url.URL, query string, logger log.Logger) {
+	method := grpcWebMethods[endpoint]
+	target, err := a.settings.resolve(indexerURL, "/"+grpcWebService+"/"+method)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	values, _ := url.ParseQuery(query)
+
+	ctx, cancel := context.WithTimeout(req.Context(), a.settings.endpointTimeout(endpoint))
+	defer cancel()
+
+	start := time.Now()
+	names, err := a.callGRPCWeb(ctx, target, encodeStrings(values["namespace"]), req.Header.Get(requestIDHeader))
+	duration := time.Since(start)
+	proxyUpstreamDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+
+	var statusErr *grpcWebError
+	switch {
+	case errors.As(err, &statusErr):
+		status, ok := grpcWebStatuses[statusErr.code]
+		if !ok {
+			status = http.StatusBadGateway
+		}
+		logger.Info("Upstream request completed", "method", method, "grpcStatus", statusErr.code, "duration", duration)
+		writeJSONError(w, status, statusErr.Error())
+		return
+	case err != nil:
+		logger.Error("Failed to proxy request", "method", method, "error", err, "duration", duration)
+		writeProxyError(w, indexerURL, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(upstreamDurationHeader, strconv.FormatInt(duration.Milliseconds(), 10))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(names); err != nil {
+		logger.Error("Failed to write response", "error", err)
+	}
+}
+
+// callGRPCWeb makes a unary gRPC-Web call with the encoded request message
+// and returns the strings of field 1 of the response message.
+func (a *App) callGRPCWeb(ctx context.Context, target *url.URL, message []byte, requestID string) ([]string, error) {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	frame = append(frame, message...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(frame))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", grpcWebContentType)
+	req.Header.Set("Accept", grpcWebContentType)
+	req.Header.Set("X-Grpc-Web", "1")
+	if requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+	a.setUpstreamHeaders(req)
+	a.authorize(req)
+	setIdentityHeaders(req)
+
+	resp, err := a.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("astrolabe server returned %s", resp.Status)
+	}
+	if err := limitResponse(resp, a.settings.MaxResponseBytes); err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeGRPCWebResponse(resp.Header, body)
+}
+
+// decodeGRPCWebResponse reads the frames of a unary gRPC-Web response and
+// returns the strings of field 1 of its message. The status is taken from the
+// trailer frame, or from the headers of a trailers-only response.
+func decodeGRPCWebResponse(header http.Header, body []byte) ([]string, error) {
+	var message []byte
+	trailers := textproto.MIMEHeader(header)
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return nil, fmt.Errorf("%w: truncated gRPC-Web frame", errInvalidUpstreamResponse)
+		}
+		flag, length := body[0], binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(length) {
+			return nil, fmt.Errorf("%w: truncated gRPC-Web frame", errInvalidUpstreamResponse)
+		}
+		payload := body[5 : 5+length]
+		body = body[5+length:]
+
+		if flag&grpcWebTrailerFlag != 0 {
+			// The trailers are header lines without the closing blank line
+			reader := bufio.NewReader(bytes.NewReader(slices.Concat(payload, []byte("\r\n"))))
+			parsed, err := textproto.NewReader(reader).ReadMIMEHeader()
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid gRPC-Web trailers: %v", errInvalidUpstreamResponse, err)
+			}
+			trailers = parsed
+			continue
+		}
+		message = payload
+	}
+
+	code, err := strconv.Atoi(trailers.Get("Grpc-Status"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing gRPC status", errInvalidUpstreamResponse)
+	}
+	if code != 0 {
+		msg, _ := url.PathUnescape(trailers.Get("Grpc-Message"))
+		return nil, &grpcWebError{code: code, message: msg}
+	}
+	names, err := decodeStrings(message)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidUpstreamResponse, err)
+	}
+	return names, nil
+}
+
+// encodeStrings encodes a protobuf message holding values in repeated string
+// field 1.
+func encodeStrings(values []string) []byte {
+	var message []byte
+	for _, value := range values {
+		message = protowire.AppendTag(message, 1, protowire.BytesType)
+		message = protowire.AppendString(message, value)
+	}
+	return message
+}
+
+// decodeStrings returns the values of repeated string field 1 of a protobuf
+// message, skipping any other field. An empty message is an empty list.
+func decodeStrings(message []byte) ([]string, error) {
+	values := []string{}
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		message = message[n:]
+		if num == 1 && typ == protowire.BytesType {
+			value, n := protowire.ConsumeString(message)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			values = append(values, value)
+			message = message[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, message)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		message = message[n:]
+	}
+	return values, nil
+}
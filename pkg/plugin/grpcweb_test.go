@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// grpcWebFrame frames a gRPC-Web message or, with the trailer flag, trailers.
+func grpcWebFrame(flag byte, payload []byte) []byte {
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+// TestProxyGRPCWeb checks that the namespaces and releases are fetched over
+// gRPC-Web with the grpcweb protocol and relayed as the REST API would.
+func TestProxyGRPCWeb(t *testing.T) {
+	var namespaces []string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/graph" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"nodes":[],"edges":[]}`))
+			return
+		}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != grpcWebContentType {
+			t.Errorf("request should be a gRPC-Web POST, got %s with %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		request, err := decodeStrings(body[5:])
+		if err != nil {
+			t.Errorf("request should be a valid message: %s", err)
+		}
+		namespaces = request
+
+		w.Header().Set("Content-Type", grpcWebContentType)
+		switch r.URL.Path {
+		case "/astrolabe.v1.StateService/ListNamespaces":
+			// An unknown field 2 is skipped
+			message := protowire.AppendTag(encodeStrings([]string{"default", "kube-system"}), 2, protowire.VarintType)
+			message = protowire.AppendVarint(message, 7)
+			_, _ = w.Write(grpcWebFrame(0, message))
+			_, _ = w.Write(grpcWebFrame(grpcWebTrailerFlag, []byte("grpc-status: 0\r\ngrpc-message: \r\n")))
+		case "/astrolabe.v1.StateService/ListReleases":
+			if slices.Contains(request, "missing") {
+				_, _ = w.Write(grpcWebFrame(grpcWebTrailerFlag, []byte("grpc-status: 5\r\ngrpc-message: namespace%20not%20found\r\n")))
+				return
+			}
+			_, _ = w.Write(grpcWebFrame(0, encodeStrings(nil)))
+			_, _ = w.Write(grpcWebFrame(grpcWebTrailerFlag, []byte("grpc-status: 0\r\n")))
+		default:
+			// Trailers-only response
+			w.Header().Set("Grpc-Status", "12")
+		}
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","protocol":"grpcweb"}`)
+	for _, tc := range []struct {
+		name          string
+		endpoint      string
+		path          string
+		expStatus     int
+		expBody       string
+		expNamespaces []string
+	}{
+		{name: "namespaces", endpoint: "namespaces", path: "/namespaces", expStatus: http.StatusOK, expBody: `["default","kube-system"]`},
+		{name: "releases", endpoint: "releases", path: "/releases?namespace=a&namespace=b", expStatus: http.StatusOK, expBody: `[]`, expNamespaces: []string{"a", "b"}},
+		{name: "grpc status", endpoint: "releases", path: "/releases?namespace=missing", expStatus: http.StatusNotFound, expNamespaces: []string{"missing"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			namespaces = nil
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, tc.path, nil), tc.endpoint, "")
+
+			if rec.Code != tc.expStatus {
+				t.Fatalf("status should be %d, got %d: %s", tc.expStatus, rec.Code, rec.Body)
+			}
+			if body := strings.TrimSpace(rec.Body.String()); tc.expBody != "" && body != tc.expBody {
+				t.Errorf("body should be %s, got %s", tc.expBody, body)
+			}
+			if !slices.Equal(namespaces, tc.expNamespaces) {
+				t.Errorf("namespaces should be %v, got %v", tc.expNamespaces, namespaces)
+			}
+		})
+	}
+
+	t.Run("other endpoints use REST", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+		if rec.Code != http.StatusOK {
+			t.Errorf("status should be %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+		}
+	})
+}
+
+// TestDecodeGRPCWebResponse checks the status handling of gRPC-Web responses.
+func TestDecodeGRPCWebResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		header  http.Header
+		body    []byte
+		expCode int
+		expErr  bool
+	}{
+		{name: "trailers-only", header: http.Header{"Grpc-Status": {"14"}}, expCode: 14},
+		{name: "missing status", body: grpcWebFrame(0, encodeStrings([]string{"a"})), expErr: true},
+		{name: "truncated frame", body: grpcWebFrame(0, encodeStrings([]string{"a"}))[:6], expErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decodeGRPCWebResponse(tc.header, tc.body)
+			var statusErr *grpcWebError
+			switch {
+			case tc.expErr:
+				if err == nil {
+					t.Error("decoding should fail")
+				}
+			case err == nil:
+				t.Error("decoding should report the gRPC status")
+			default:
+				if !errors.As(err, &statusErr) || statusErr.code != tc.expCode {
+					t.Errorf("gRPC status should be %d, got %v", tc.expCode, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// upstreamRequest is a request received by a fakeStateServer.
+type upstreamRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+}
+
+// fakeStateServer mimics the routes of the state server with canned
+// responses and records the requests it receives.
+type fakeStateServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []upstreamRequest
+}
+
+// fakeStateServerBodies are the canned responses by upstream path.
+var fakeStateServerBodies = map[string]string{
+	"/api/v1/namespaces":             `["default","kube-system"]`,
+	"/api/v1/releases":               `["web"]`,
+	"/api/v1/releases/web/resources": `[{"kind":"Pod","name":"web-0"}]`,
+	"/api/v1/graph":                  `{"nodes":[{"uid":"a","kind":"Pod","name":"web-0"}],"edges":[]}`,
+	"/api/v1/graph/nodes":            `[{"uid":"a","kind":"Pod","name":"web-0"}]`,
+	"/api/v1/graph/edges":            `[]`,
+	"/api/v1/resources":              `[{"kind":"Pod","name":"web-0","status":"Running"}]`,
+	"/api/v1/resources/a":            `{"uid":"a","kind":"Pod","name":"web-0"}`,
+	"/api/v1/workloads":              `[]`,
+	"/api/v1/events":                 `[]`,
+	"/api/v1/kinds":                  `["Pod"]`,
+	"/api/v1/search":                 `[{"kind":"Pod","name":"web-0"}]`,
+	"/api/v1/healthz":                `{"status":"ok"}`,
+}
+
+// newFakeStateServer starts a fakeStateServer, closed when the test ends.
+func newFakeStateServer(t *testing.T) *fakeStateServer {
+	t.Helper()
+	s := &fakeStateServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeStateServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, upstreamRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+	})
+	s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/api/v1/graph/stream":
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {}\n\n"))
+	case "/metrics":
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte("astrolabe_up 1\n"))
+	default:
+		body, ok := fakeStateServerBodies[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// Internal headers the proxy should not relay
+		w.Header().Set("Server", "astrolabe-state")
+		w.Header().Set("X-Debug-Shard", "3")
+		w.Header().Set(requestIDHeader, r.Header.Get(requestIDHeader))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+// received returns the requests received so far and forgets them.
+func (s *fakeStateServer) received() []upstreamRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+// newFakeStateServerApp starts a fakeStateServer and returns it along with the
+// routes of an App pointed at it. jsonData holds additional settings.
+func newFakeStateServerApp(t *testing.T, jsonData string) (*fakeStateServer, http.Handler) {
+	t.Helper()
+	server := newFakeStateServer(t)
+	settings := `"indexerUrl":"` + server.URL + `"`
+	if jsonData != "" {
+		settings += "," + jsonData
+	}
+	app := newTestApp(t, "{"+settings+"}")
+	mux := http.NewServeMux()
+	if err := app.registerRoutes(mux); err != nil {
+		t.Fatalf("registerRoutes error: %s", err)
+	}
+	return server, mux
+}
+
+// TestProxyRoutes drives every proxied route through the mux and checks what
+// reaches the state server.
+func TestProxyRoutes(t *testing.T) {
+	server, mux := newFakeStateServerApp(t, `"maxRetries":0`)
+
+	for _, tc := range []struct {
+		path     string
+		expPath  string
+		expQuery url.Values
+		identity bool
+	}{
+		{path: "/namespaces", expPath: "/api/v1/namespaces", identity: true},
+		{path: "/releases?namespace=default", expPath: "/api/v1/releases", expQuery: url.Values{"namespace": {"default"}}, identity: true},
+		{path: "/releases/web/resources?kind=Pod", expPath: "/api/v1/releases/web/resources", expQuery: url.Values{"kind": {"Pod"}}, identity: true},
+		{path: "/graph?namespace=default&release=web", expPath: "/api/v1/graph", expQuery: url.Values{"namespace": {"default"}, "release": {"web"}}, identity: true},
+		{path: "/graph/nodes?limit=10", expPath: "/api/v1/graph/nodes", expQuery: url.Values{"limit": {"10"}}, identity: true},
+		{path: "/graph/edges?continue=abc", expPath: "/api/v1/graph/edges", expQuery: url.Values{"continue": {"abc"}}, identity: true},
+		{path: "/graph/stream?namespace=default", expPath: "/api/v1/graph/stream", expQuery: url.Values{"namespace": {"default"}}, identity: true},
+		{path: "/resources?kind=Pod&dropped=1", expPath: "/api/v1/resources", expQuery: url.Values{"kind": {"Pod"}}, identity: true},
+		{path: "/resources/a", expPath: "/api/v1/resources/a", identity: true},
+		{path: "/workloads?release=web", expPath: "/api/v1/workloads", expQuery: url.Values{"release": {"web"}}, identity: true},
+		{path: "/events?since=5m", expPath: "/api/v1/events", expQuery: url.Values{"since": {"5m"}}, identity: true},
+		{path: "/kinds?namespace=default", expPath: "/api/v1/kinds", expQuery: url.Values{"namespace": {"default"}}, identity: true},
+		{path: "/search?q=web&kind=Pod", expPath: "/api/v1/search", expQuery: url.Values{"q": {"web"}, "kind": {"Pod"}}, identity: true},
+		{path: "/state-metrics", expPath: "/metrics", identity: true},
+		{path: "/healthz", expPath: "/api/v1/healthz"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: 3})
+			ctx = backend.WithUser(ctx, &backend.User{Login: "alice"})
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil).WithContext(ctx)
+			req.Header.Set("Accept", "application/json")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("response status should be %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+			requests := server.received()
+			if len(requests) != 1 {
+				t.Fatalf("state server should receive 1 request, got %d", len(requests))
+			}
+			got := requests[0]
+			if got.Method != http.MethodGet {
+				t.Errorf("upstream method should be GET, got %s", got.Method)
+			}
+			if got.Path != tc.expPath {
+				t.Errorf("upstream path should be %s, got %s", tc.expPath, got.Path)
+			}
+			if got.Query.Encode() != tc.expQuery.Encode() {
+				t.Errorf("upstream query should be %q, got %q", tc.expQuery.Encode(), got.Query.Encode())
+			}
+			if tc.identity {
+				if v := got.Header.Get(grafanaUserHeader); v != "alice" {
+					t.Errorf("%s should be alice, got %q", grafanaUserHeader, v)
+				}
+				if v := got.Header.Get(grafanaOrgIDHeader); v != "3" {
+					t.Errorf("%s should be 3, got %q", grafanaOrgIDHeader, v)
+				}
+				if v := got.Header.Get("Accept"); v != "application/json" {
+					t.Errorf("Accept should be forwarded, got %q", v)
+				}
+			}
+			if !strings.HasPrefix(got.Header.Get("User-Agent"), "astrolabe-grafana/") {
+				t.Errorf("User-Agent should be the plugin's, got %q", got.Header.Get("User-Agent"))
+			}
+		})
+	}
+}
+
+// TestProxyUpstreamHeaders checks that the configured headers reach the state
+// server without replacing the credentials set by the plugin.
+func TestProxyUpstreamHeaders(t *testing.T) {
+	t.Setenv("ASTROLABE_TENANT", "acme")
+	server := newFakeStateServer(t)
+	app := newTestApp(t, `{"indexerUrl":"`+server.URL+`","upstreamHeaders":{"X-Tenant-ID":"${env:ASTROLABE_TENANT}","X-Env":"prod"}}`)
+	app.settings.IndexerToken = "s3cret"
+
+	rec := httptest.NewRecorder()
+	app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/namespaces", nil), "namespaces", "")
+
+	requests := server.received()
+	if len(requests) != 1 {
+		t.Fatalf("state server should receive 1 request, got %d", len(requests))
+	}
+	got := requests[0].Header
+	if v := got.Get("X-Tenant-ID"); v != "acme" {
+		t.Errorf("X-Tenant-ID should be acme, got %q", v)
+	}
+	if v := got.Get("X-Env"); v != "prod" {
+		t.Errorf("X-Env should be prod, got %q", v)
+	}
+	if v := got.Get("Authorization"); v != "Bearer s3cret" {
+		t.Errorf("Authorization should be the configured token, got %q", v)
+	}
+}
+
+// TestProxyResponseHeaders checks that only the allowed upstream response
+// headers reach the client.
+func TestProxyResponseHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		jsonData   string
+		expRelayed []string
+		expDropped []string
+	}{
+		{name: "defaults", expRelayed: []string{"Content-Type", "X-Request-ID"}, expDropped: []string{"Server", "X-Debug-Shard"}},
+		{name: "extended", jsonData: `"responseHeaders":["x-debug-shard"]`, expRelayed: []string{"Content-Type", "X-Request-ID", "X-Debug-Shard"}, expDropped: []string{"Server"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, mux := newFakeStateServerApp(t, tc.jsonData)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/namespaces", nil))
+
+			for _, name := range tc.expRelayed {
+				if rec.Header().Get(name) == "" {
+					t.Errorf("%s should be relayed", name)
+				}
+			}
+			for _, name := range tc.expDropped {
+				if value := rec.Header().Get(name); value != "" {
+					t.Errorf("%s should be dropped, got %q", name, value)
+				}
+			}
+		})
+	}
+}
+
+// TestProxyForwardCookies checks that only the allowed cookies reach the
+// state server.
+func TestProxyForwardCookies(t *testing.T) {
+	server := newFakeStateServer(t)
+
+	for _, tc := range []struct {
+		name      string
+		jsonData  string
+		expCookie string
+	}{
+		{name: "none allowed", jsonData: `{"indexerUrl":"` + server.URL + `"}`},
+		{name: "allowlist", jsonData: `{"indexerUrl":"` + server.URL + `","forwardCookies":["proxy_session","other"]}`, expCookie: "proxy_session=abc; other=1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t, tc.jsonData)
+			req := httptest.NewRequest(http.MethodGet, "/namespaces", nil)
+			req.Header.Add("Cookie", "grafana_session=s3cret; proxy_session=abc")
+			req.Header.Add("Cookie", "other=1; grafana_session_expiry=123")
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, req, "namespaces", "")
+
+			requests := server.received()
+			if len(requests) != 1 {
+				t.Fatalf("state server should receive 1 request, got %d", len(requests))
+			}
+			got := requests[0].Header.Values("Cookie")
+			if tc.expCookie == "" {
+				if len(got) != 0 {
+					t.Errorf("no cookie should be forwarded, got %q", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0] != tc.expCookie {
+				t.Errorf("Cookie should be %q, got %q", tc.expCookie, got)
+			}
+		})
+	}
+}
+
+// TestFetchForwardCookies checks that the calls the plugin makes itself, and
+// the refreshes of cached graphs, carry the allowed cookies too.
+func TestFetchForwardCookies(t *testing.T) {
+	server := newFakeStateServer(t)
+	app := newTestApp(t, `{"indexerUrl":"`+server.URL+`","forwardCookies":["proxy_session"],"cacheTtlSeconds":60}`)
+	defer app.Dispose()
+	mux := http.NewServeMux()
+	if err := app.registerRoutes(mux); err != nil {
+		t.Fatalf("registerRoutes error: %s", err)
+	}
+
+	for _, path := range []string{"/overview", "/graph/diff?from=2024-01-01T00:00:00Z&to=2024-01-02T00:00:00Z", "/graph"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Cookie", "grafana_session=s3cret; proxy_session=abc")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status should be 200, got %d: %s", path, rec.Code, rec.Body.String())
+		}
+	}
+	app.graphCache.refreshAll()
+
+	requests := server.received()
+	if len(requests) == 0 {
+		t.Fatal("state server should receive requests")
+	}
+	for _, r := range requests {
+		if got := r.Header.Values("Cookie"); len(got) != 1 || got[0] != "proxy_session=abc" {
+			t.Errorf("%s: Cookie should be %q, got %q", r.Path, "proxy_session=abc", got)
+		}
+	}
+}
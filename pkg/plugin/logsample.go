@@ -0,0 +1,23 @@
+package plugin
+
+import "sync/atomic"
+
+// logSampler picks one in every rate requests to log. A nil sampler picks
+// every request.
+type logSampler struct {
+	rate  uint64
+	count atomic.Uint64
+}
+
+func newLogSampler(rate int) *logSampler {
+	return &logSampler{rate: uint64(rate)}
+}
+
+// sample reports whether the current request should be logged. The first
+// request is always picked.
+func (s *logSampler) sample() bool {
+	if s == nil {
+		return true
+	}
+	return (s.count.Add(1)-1)%s.rate == 0
+}
@@ -0,0 +1,41 @@
+package plugin
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLogSampler(t *testing.T) {
+	var unsampled *logSampler
+	for i := 0; i < 3; i++ {
+		if !unsampled.sample() {
+			t.Fatal("nil sampler should pick every request")
+		}
+	}
+
+	sampler := newLogSampler(10)
+	if !sampler.sample() {
+		t.Error("first request should be picked")
+	}
+
+	// 100 goroutines each sampling 100 requests pick exactly one in ten
+	sampler = newLogSampler(10)
+	var picked atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if sampler.sample() {
+					picked.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := picked.Load(); n != 1000 {
+		t.Errorf("sampled requests should be 1000, got %d", n)
+	}
+}
@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics are registered with the default registry, which the plugin SDK
+// exposes through the plugin's /metrics endpoint.
+var (
+	proxyRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "astrolabe",
+		Subsystem: "proxy",
+		Name:      "requests_total",
+		Help:      "Total number of proxied requests by endpoint and response status.",
+	}, []string{"endpoint", "status"})
+
+	proxyUpstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "astrolabe",
+		Subsystem: "proxy",
+		Name:      "upstream_duration_seconds",
+		Help:      "Duration of upstream calls to the astrolabe server.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	proxyRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "astrolabe",
+		Subsystem: "proxy",
+		Name:      "requests_in_flight",
+		Help:      "Number of proxied requests currently being served.",
+	})
+)
+
+// streamingEndpoints lists the endpoints serving long-lived event streams,
+// which are not bound by the request timeout.
+var streamingEndpoints = map[string]bool{
+	"graph_stream": true,
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,38 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestProxyMetrics checks that proxied requests are counted per known endpoint.
+func TestProxyMetrics(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxRetries":0}`)
+
+	before := testutil.ToFloat64(proxyRequestsTotal.WithLabelValues("releases", "404"))
+	app.proxyToIndexer(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/releases", nil), "releases", "")
+	after := testutil.ToFloat64(proxyRequestsTotal.WithLabelValues("releases", "404"))
+
+	if after-before != 1 {
+		t.Errorf("requests_total{endpoint=releases,status=404} should increase by 1, got %v", after-before)
+	}
+	if v := testutil.ToFloat64(proxyRequestsInFlight); v != 0 {
+		t.Errorf("requests_in_flight should be 0 after the request, got %v", v)
+	}
+
+	before = testutil.ToFloat64(proxyRequestsTotal.WithLabelValues("resource", "404"))
+	app.proxyToIndexer(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/resources/some-uid", nil), "resource", "some-uid")
+	after = testutil.ToFloat64(proxyRequestsTotal.WithLabelValues("resource", "404"))
+
+	if after-before != 1 {
+		t.Errorf("single resource lookups should be reported as resource, got %v", after-before)
+	}
+}
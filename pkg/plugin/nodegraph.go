@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// nodeGraphResponse holds the nodes and edges frames consumed by Grafana's
+// Node Graph panel.
+type nodeGraphResponse struct {
+	Frames []*data.Frame `json:"frames"`
+}
+
+// toNodeGraph converts a state server graph into the frames of the Node Graph
+// panel. Nodes are titled by name with the kind as subtitle and the status as
+// main stat; edges show their type.
+func toNodeGraph(body []byte) ([]byte, error) {
+	var graph struct {
+		Nodes []struct {
+			UID       string `json:"uid"`
+			Kind      string `json:"kind"`
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+			Status    string `json:"status"`
+		} `json:"nodes"`
+		Edges []struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+			Type string `json:"type"`
+		} `json:"edges"`
+	}
+	if err := json.Unmarshal(body, &graph); err != nil {
+		return nil, fmt.Errorf("graph is not an object with nodes and edges: %w", err)
+	}
+
+	var ids, titles, subtitles, stats, namespaces []string
+	for _, node := range graph.Nodes {
+		ids = append(ids, node.UID)
+		titles = append(titles, node.Name)
+		subtitles = append(subtitles, node.Kind)
+		stats = append(stats, node.Status)
+		namespaces = append(namespaces, node.Namespace)
+	}
+	nodes := data.NewFrame("nodes",
+		data.NewField("id", nil, ids),
+		data.NewField("title", nil, titles),
+		data.NewField("subtitle", nil, subtitles),
+		data.NewField("mainstat", nil, stats),
+		data.NewField("detail__namespace", nil, namespaces),
+	)
+
+	var edgeIDs, sources, targets, types []string
+	for i, edge := range graph.Edges {
+		edgeIDs = append(edgeIDs, strconv.Itoa(i))
+		sources = append(sources, edge.From)
+		targets = append(targets, edge.To)
+		types = append(types, edge.Type)
+	}
+	edges := data.NewFrame("edges",
+		data.NewField("id", nil, edgeIDs),
+		data.NewField("source", nil, sources),
+		data.NewField("target", nil, targets),
+		data.NewField("mainstat", nil, types),
+	)
+
+	for _, frame := range []*data.Frame{nodes, edges} {
+		frame.SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph})
+	}
+	return json.Marshal(nodeGraphResponse{Frames: []*data.Frame{nodes, edges}})
+}
+
+// serveGraphAs proxies the graph and converts it, e.g. with toNodeGraph, to a
+// body of the given content type. The graph is fetched as usual, so it is
+// cached and shared with the plain graph requests; only successful JSON
+// responses are converted.
+func (a *App) serveGraphAs(w http.ResponseWriter, req *http.Request, convert func([]byte) ([]byte, error), contentType string) {
+	// Ask for the graph as uncompressed JSON so it can be converted
+	req = req.Clone(req.Context())
+	req.Header.Del("Accept-Encoding")
+	req.Header.Set("Accept", "application/json")
+
+	graph := newSharedResponse()
+	a.proxyGraph(graph, req)
+
+	if graph.status != http.StatusOK || !isJSONContentType(graph.header.Get("Content-Type")) {
+		graph.replay(w, "")
+		return
+	}
+	body, err := convert(graph.body.Bytes())
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("%v: %v", errInvalidUpstreamResponse, err))
+		return
+	}
+
+	for key, values := range graph.header {
+		w.Header()[key] = values
+	}
+	// Validators and the length belong to the upstream representation
+	for _, key := range []string{"Content-Length", "ETag", "Last-Modified"} {
+		w.Header().Del(key)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
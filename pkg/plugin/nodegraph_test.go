@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const sampleGraph = `{"nodes":[` +
+	`{"uid":"d1","kind":"Deployment","name":"web","namespace":"default","status":"Ready"},` +
+	`{"uid":"p1","kind":"Pod","name":"web-abc","namespace":"default","status":"Pending"}` +
+	`],"edges":[{"from":"d1","to":"p1","type":"owns"}]}`
+
+// TestHandleGraphNodeGraph checks the conversion of the graph to Node Graph
+// frames and that the graph is passed through without format=nodegraph.
+func TestHandleGraphNodeGraph(t *testing.T) {
+	var gotQuery string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(sampleGraph))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	if err := app.registerRoutes(mux); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("passthrough", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/graph?namespace=default", nil))
+		if rec.Body.String() != sampleGraph {
+			t.Errorf("graph should be passed through unchanged, got %q", rec.Body.String())
+		}
+	})
+
+	t.Run("nodegraph", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/graph?namespace=default&format=nodegraph", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("response status should be 200, got %d", rec.Code)
+		}
+		if gotQuery != "namespace=default" {
+			t.Errorf("format should not be forwarded, got query %q", gotQuery)
+		}
+		var body struct {
+			Frames []*data.Frame `json:"frames"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || len(body.Frames) != 2 {
+			t.Fatalf("response should hold two frames, got %q", rec.Body.String())
+		}
+		nodes, edges := body.Frames[0], body.Frames[1]
+
+		for _, tc := range []struct {
+			frame *data.Frame
+			field string
+			exp   []string
+		}{
+			{frame: nodes, field: "id", exp: []string{"d1", "p1"}},
+			{frame: nodes, field: "title", exp: []string{"web", "web-abc"}},
+			{frame: nodes, field: "subtitle", exp: []string{"Deployment", "Pod"}},
+			{frame: nodes, field: "mainstat", exp: []string{"Ready", "Pending"}},
+			{frame: edges, field: "source", exp: []string{"d1"}},
+			{frame: edges, field: "target", exp: []string{"p1"}},
+			{frame: edges, field: "mainstat", exp: []string{"owns"}},
+		} {
+			field, _ := tc.frame.FieldByName(tc.field)
+			if field == nil || field.Len() != len(tc.exp) {
+				t.Errorf("%s frame should have %d values in %s", tc.frame.Name, len(tc.exp), tc.field)
+				continue
+			}
+			for i, exp := range tc.exp {
+				if got := field.At(i); got != exp {
+					t.Errorf("%s.%s[%d] should be %q, got %v", tc.frame.Name, tc.field, i, exp, got)
+				}
+			}
+		}
+		if nodes.Meta == nil || nodes.Meta.PreferredVisualization != data.VisTypeNodeGraph {
+			t.Error("nodes frame should prefer the node graph visualization")
+		}
+	})
+}
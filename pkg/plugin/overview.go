@@ -0,0 +1,209 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// overview is the summary returned by /overview. Counts of sub-calls that
+// failed are null and the failure is reported in Errors, keyed by endpoint.
+type overview struct {
+	Namespaces *int              `json:"namespaces"`
+	Releases   *int              `json:"releases"`
+	Pods       map[string]int    `json:"pods"`
+	Errors     map[string]string `json:"errors,omitempty"`
+}
+
+// handleOverview summarizes the cluster for landing dashboards: the number of
+// namespaces and releases and the pods by status, fetched from the state
+// server concurrently. The namespace param narrows the releases and pods
+// counted. If some of the calls fail the others are still returned; only if
+// all fail is the response a 502.
+func (a *App) handleOverview(w http.ResponseWriter, req *http.Request) {
+	if !a.track() {
+		writeJSONError(w, http.StatusServiceUnavailable, "plugin is shutting down")
+		return
+	}
+	defer a.inFlight.Done()
+
+	if a.settingsErr != nil {
+		writeJSONError(w, http.StatusInternalServerError, a.settingsErr.Error())
+		return
+	}
+	a.waitReady(req.Context())
+
+	indexerURL, err := a.getIndexerURL(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query := url.Values{"namespace": req.URL.Query()["namespace"]}.Encode()
+	query = withDefaultNamespace("resources", query, a.settings.DefaultNamespace)
+
+	var (
+		result overview
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+	)
+	calls := map[string]func(*url.URL) error{
+		"namespaces": func(target *url.URL) error {
+			namespaces, err := a.fetchStrings(req, "namespaces", target)
+			if err != nil {
+				return err
+			}
+			// Restricted orgs only learn about the namespaces they may view
+			orgID := backend.PluginConfigFromContext(req.Context()).OrgID
+			if allowed, restricted := a.settings.allowedNamespaces(orgID); restricted {
+				namespaces = slices.DeleteFunc(namespaces, func(ns string) bool { return !slices.Contains(allowed, ns) })
+			}
+			n := len(namespaces)
+			mu.Lock()
+			result.Namespaces = &n
+			mu.Unlock()
+			return nil
+		},
+		"releases": func(target *url.URL) error {
+			target.RawQuery = query
+			releases, err := a.fetchStrings(req, "releases", target)
+			if err != nil {
+				return err
+			}
+			n := len(releases)
+			mu.Lock()
+			result.Releases = &n
+			mu.Unlock()
+			return nil
+		},
+		"resources": func(target *url.URL) error {
+			pods, err := a.countPods(req, target, query)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			result.Pods = pods
+			mu.Unlock()
+			return nil
+		},
+	}
+	for name, count := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			target, err := a.settings.resolve(indexerURL, a.settings.apiPath(name, ""))
+			if err == nil {
+				err = count(target)
+			}
+			if err != nil {
+				log.DefaultLogger.Warn("Overview sub-call failed", "endpoint", name, "error", err)
+				mu.Lock()
+				if result.Errors == nil {
+					result.Errors = map[string]string{}
+				}
+				result.Errors[name] = err.Error()
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if len(result.Errors) == len(calls) {
+		status = http.StatusBadGateway
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.DefaultLogger.Error("Failed to write overview response", "error", err)
+	}
+}
+
+// fetchStrings fetches a JSON list of names, such as the namespaces.
+func (a *App) fetchStrings(req *http.Request, endpoint string, target *url.URL) ([]string, error) {
+	_, body, err := a.fetch(req.Context(), endpoint, target)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if err := json.Unmarshal(body, &names); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidUpstreamResponse, err)
+	}
+	return names, nil
+}
+
+// maxListPages caps the pages of a resource list followed by the plugin.
+const maxListPages = 100
+
+// nextPageToken returns the continue token of the page read after pages
+// pages, or "" once the list is complete. A token repeating prev, or more
+// than maxListPages pages, is an error so a misbehaving state server can't
+// keep the plugin paging until the request times out.
+func nextPageToken(header http.Header, prev string, pages int) (string, error) {
+	token := header.Get("X-Continue-Token")
+	switch {
+	case token == "":
+		return "", nil
+	case token == prev:
+		return "", fmt.Errorf("%w: repeated continue token %q", errInvalidUpstreamResponse, token)
+	case pages >= maxListPages:
+		return "", fmt.Errorf("resource list has more than %d pages", maxListPages)
+	}
+	return token, nil
+}
+
+// countPods counts the pods in the resource list by status, following the
+// list's pages.
+func (a *App) countPods(req *http.Request, target *url.URL, query string) (map[string]int, error) {
+	params, _ := url.ParseQuery(query)
+	params.Set("kind", "Pod")
+
+	pods := map[string]int{}
+	for pages := 1; ; pages++ {
+		target.RawQuery = params.Encode()
+		header, body, err := a.fetch(req.Context(), "resources", target)
+		if err != nil {
+			return nil, err
+		}
+
+		// The list is either an array or an object holding it in "items"
+		var list struct {
+			Items json.RawMessage `json:"items"`
+		}
+		items := json.RawMessage(body)
+		if err := json.Unmarshal(body, &list); err == nil {
+			items = list.Items
+		}
+		var resources []struct {
+			Kind   string `json:"kind"`
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(items, &resources); err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidUpstreamResponse, err)
+		}
+		for _, resource := range resources {
+			if resource.Kind != "Pod" {
+				continue
+			}
+			status := resource.Status
+			if status == "" {
+				status = "Unknown"
+			}
+			pods[status]++
+		}
+
+		token, err := nextPageToken(header, params.Get("continue"), pages)
+		if err != nil {
+			return nil, err
+		}
+		if token == "" {
+			return pods, nil
+		}
+		params.Set("continue", token)
+	}
+}
@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// TestHandleOverview checks the counts of the overview and that failed
+// sub-calls are reported alongside the others.
+func TestHandleOverview(t *testing.T) {
+	var failing map[string]bool
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing[r.URL.Path] {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/namespaces":
+			_, _ = w.Write([]byte(`["default","kube-system","web"]`))
+		case "/api/v1/releases":
+			if ns := r.URL.Query().Get("namespace"); ns != "web" {
+				t.Errorf("releases namespace should be web, got %q", ns)
+			}
+			_, _ = w.Write([]byte(`["frontend"]`))
+		case "/api/v1/resources":
+			if kind := r.URL.Query().Get("kind"); kind != "Pod" {
+				t.Errorf("resources kind should be Pod, got %q", kind)
+			}
+			if r.URL.Query().Get("continue") == "" {
+				w.Header().Set("X-Continue-Token", "page-2")
+				_, _ = w.Write([]byte(`[{"kind":"Pod","status":"Running"},{"kind":"Service","status":"Ready"}]`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"items":[{"kind":"Pod","status":"Pending"},{"kind":"Pod","status":"Running"}]}`))
+		}
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxRetries":0}`)
+	mux := http.NewServeMux()
+	if err := app.registerRoutes(mux); err != nil {
+		t.Fatal(err)
+	}
+
+	three, one := 3, 1
+	for _, tc := range []struct {
+		name      string
+		failing   map[string]bool
+		expStatus int
+		exp       overview
+		expErrors []string
+	}{
+		{
+			name:      "all succeed",
+			expStatus: http.StatusOK,
+			exp:       overview{Namespaces: &three, Releases: &one, Pods: map[string]int{"Running": 2, "Pending": 1}},
+		},
+		{
+			name:      "releases fail",
+			failing:   map[string]bool{"/api/v1/releases": true},
+			expStatus: http.StatusOK,
+			exp:       overview{Namespaces: &three, Pods: map[string]int{"Running": 2, "Pending": 1}},
+			expErrors: []string{"releases"},
+		},
+		{
+			name:      "all fail",
+			failing:   map[string]bool{"/api/v1/namespaces": true, "/api/v1/releases": true, "/api/v1/resources": true},
+			expStatus: http.StatusBadGateway,
+			expErrors: []string{"namespaces", "releases", "resources"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			failing = tc.failing
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/overview?namespace=web", nil))
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			var got overview
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("response should be JSON, got %q", rec.Body.String())
+			}
+			for _, name := range tc.expErrors {
+				if got.Errors[name] == "" {
+					t.Errorf("errors should include %s, got %v", name, got.Errors)
+				}
+			}
+			if len(got.Errors) != len(tc.expErrors) {
+				t.Errorf("errors should have %d entries, got %v", len(tc.expErrors), got.Errors)
+			}
+			got.Errors = nil
+			if !reflect.DeepEqual(got, tc.exp) {
+				exp, _ := json.Marshal(tc.exp)
+				t.Errorf("overview should be %s, got %s", exp, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestCountPodsPaging checks that pod counting stops on continue tokens that
+// repeat or never end instead of paging until the request times out.
+func TestCountPodsPaging(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		token    func(page int) string
+		expCalls int
+	}{
+		{name: "repeated token", token: func(int) string { return "same" }, expCalls: 2},
+		{name: "endless tokens", token: func(page int) string { return strconv.Itoa(page) }, expCalls: maxListPages},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.Header().Set("X-Continue-Token", tc.token(calls))
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`[{"kind":"Pod","status":"Running"}]`))
+			}))
+			defer indexer.Close()
+
+			app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+			target, _ := url.Parse(indexer.URL + "/api/v1/resources")
+			if _, err := app.countPods(httptest.NewRequest(http.MethodGet, "/overview", nil), target, ""); err == nil {
+				t.Error("counting should fail")
+			}
+			if calls != tc.expCalls {
+				t.Errorf("calls should be %d, got %d", tc.expCalls, calls)
+			}
+		})
+	}
+}
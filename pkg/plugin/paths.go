@@ -0,0 +1,40 @@
+package plugin
+
+import (
+	"net/url"
+	"strings"
+)
+
+// apiPaths maps the state server endpoints to their upstream path templates.
+// The endpoint names double as the endpoint label in metrics. {version} is
+// replaced by the configured API version and {name} by the resource UID or
+// release name of endpoints addressing a single object.
+var apiPaths = map[string]string{
+	"namespaces":        "/api/{version}/namespaces",
+	"releases":          "/api/{version}/releases",
+	"graph":             "/api/{version}/graph",
+	"graph_nodes":       "/api/{version}/graph/nodes",
+	"graph_edges":       "/api/{version}/graph/edges",
+	"graph_stream":      "/api/{version}/graph/stream",
+	"graph_watch":       "/api/{version}/graph/watch",
+	"resources":         "/api/{version}/resources",
+	"resource":          "/api/{version}/resources/{name}",
+	"resource_watch":    "/api/{version}/resources/watch",
+	"release_resources": "/api/{version}/releases/{name}/resources",
+	"workloads":         "/api/{version}/workloads",
+	"events":            "/api/{version}/events",
+	"kinds":             "/api/{version}/kinds",
+	"search":            "/api/{version}/search",
+	"healthz":           "/api/{version}/healthz",
+	"version":           "/api/{version}/version",
+	"state_metrics":     "/metrics",
+}
+
+// apiPath returns the escaped upstream path of an endpoint, which must be
+// listed in apiPaths.
+func (s *appSettings) apiPath(endpoint, name string) string {
+	return strings.NewReplacer(
+		"{version}", url.PathEscape(s.APIVersion),
+		"{name}", url.PathEscape(name),
+	).Replace(apiPaths[endpoint])
+}
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAPIPath checks that the API version and object name are substituted
+// into the upstream path templates.
+func TestAPIPath(t *testing.T) {
+	for _, tc := range []struct {
+		version  string
+		endpoint string
+		name     string
+		expPath  string
+	}{
+		{version: "v1", endpoint: "graph", expPath: "/api/v1/graph"},
+		{version: "v2", endpoint: "graph", expPath: "/api/v2/graph"},
+		{version: "v2", endpoint: "graph_nodes", expPath: "/api/v2/graph/nodes"},
+		{version: "v1", endpoint: "resource", name: "a/b", expPath: "/api/v1/resources/a%2Fb"},
+		{version: "v2", endpoint: "release_resources", name: "web", expPath: "/api/v2/releases/web/resources"},
+		{version: "v2", endpoint: "state_metrics", expPath: "/metrics"},
+	} {
+		t.Run(tc.version+" "+tc.endpoint, func(t *testing.T) {
+			s := &appSettings{APIVersion: tc.version}
+			if got := s.apiPath(tc.endpoint, tc.name); got != tc.expPath {
+				t.Errorf("path should be %q, got %q", tc.expPath, got)
+			}
+		})
+	}
+}
+
+// TestProxyAPIVersion checks that requests are sent to the configured API
+// version of the state server.
+func TestProxyAPIVersion(t *testing.T) {
+	var gotPath string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+	}))
+	defer indexer.Close()
+
+	for _, tc := range []struct {
+		jsonData string
+		expPath  string
+	}{
+		{jsonData: `{"indexerUrl":"` + indexer.URL + `"}`, expPath: "/api/v1/releases/web/resources"},
+		{jsonData: `{"indexerUrl":"` + indexer.URL + `","apiVersion":"v2"}`, expPath: "/api/v2/releases/web/resources"},
+	} {
+		t.Run(tc.expPath, func(t *testing.T) {
+			app := newTestApp(t, tc.jsonData)
+			mux := http.NewServeMux()
+			if err := app.registerRoutes(mux); err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/releases/web/resources", nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status should be 200, got %d", rec.Code)
+			}
+			if gotPath != tc.expPath {
+				t.Errorf("upstream path should be %q, got %q", tc.expPath, gotPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package plugin
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+)
+
+// queryAllowlist lists the query params forwarded upstream per endpoint, keyed
+// by the endpoint label. Any other param is dropped, as are all params of
+// endpoints missing from this list.
+var queryAllowlist = map[string][]string{
+	"namespaces":        {},
+	"releases":          {"namespace"},
+	"graph":             {"namespace", "release"},
+	"graph_nodes":       {"namespace", "release", "limit", "continue"},
+	"graph_edges":       {"namespace", "release", "limit", "continue"},
+	"graph_stream":      {"namespace", "release"},
+	"resources":         {"namespace", "release", "kind", "limit", "continue"},
+	"resource":          {},
+	"release_resources": {"namespace", "kind", "limit", "continue"},
+	"workloads":         {"namespace", "release"},
+	"events":            {"namespace", "involvedObject", "since"},
+	"kinds":             {"namespace"},
+	"search":            {"q", "namespace", "kind", "limit"},
+	"state_metrics":     {},
+}
+
+// filterQuery removes the params of rawQuery that are not allowed for the
+// endpoint. It returns the query to forward, left untouched when nothing was
+// dropped, along with the dropped keys.
+func filterQuery(endpoint, rawQuery string) (string, []string) {
+	if rawQuery == "" {
+		return "", nil
+	}
+	// Malformed pairs are skipped by ParseQuery; they are dropped as well
+	// rather than forwarded blindly.
+	query, err := url.ParseQuery(rawQuery)
+	changed := err != nil
+
+	allowed := queryAllowlist[endpoint]
+	var dropped []string
+	for key := range query {
+		if !slices.Contains(allowed, key) {
+			dropped = append(dropped, key)
+			query.Del(key)
+		}
+	}
+	if !changed && len(dropped) == 0 {
+		return rawQuery, nil
+	}
+	slices.Sort(dropped)
+	// Encode keeps every value of repeated params such as the namespaces of
+	// a multi-value dashboard variable.
+	return query.Encode(), dropped
+}
+
+// withDefaultNamespace adds namespace to a query that doesn't select one, for
+// endpoints accepting a namespace param. An empty namespace leaves the query
+// as is.
+func withDefaultNamespace(endpoint, query, namespace string) string {
+	if namespace == "" || !slices.Contains(queryAllowlist[endpoint], "namespace") {
+		return query
+	}
+	if values, _ := url.ParseQuery(query); values.Has("namespace") {
+		return query
+	}
+	param := "namespace=" + url.QueryEscape(namespace)
+	if query == "" {
+		return param
+	}
+	return query + "&" + param
+}
+
+// paramFormat is the format a query param must have to be forwarded.
+type paramFormat struct {
+	pattern *regexp.Regexp
+	maxLen  int
+	desc    string
+}
+
+// paramFormats are the formats of the params naming Kubernetes objects.
+var paramFormats = map[string]paramFormat{
+	"namespace": {
+		pattern: regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`),
+		maxLen:  63,
+		desc:    "a DNS-1123 label",
+	},
+	"kind": {
+		pattern: regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`),
+		maxLen:  63,
+		desc:    "alphanumeric, starting with a letter",
+	},
+	"release": {
+		pattern: regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`),
+		maxLen:  53,
+		desc:    "a Helm release name",
+	},
+}
+
+// validatedEndpoints are the endpoints whose forwarded query is checked with
+// validateQuery: the resource list and the graph, including its pages and
+// stream.
+var validatedEndpoints = map[string]bool{
+	"resources":    true,
+	"graph":        true,
+	"graph_nodes":  true,
+	"graph_edges":  true,
+	"graph_stream": true,
+}
+
+// validateQuery checks the namespace, kind and release params of a query
+// against paramFormats. Empty values select nothing and are allowed.
+func validateQuery(query url.Values) error {
+	for key, format := range paramFormats {
+		for _, value := range query[key] {
+			if value == "" {
+				continue
+			}
+			if len(value) > format.maxLen || !format.pattern.MatchString(value) {
+				return fmt.Errorf("invalid %s %q: must be %s of at most %d characters", key, value, format.desc, format.maxLen)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestValidateQuery checks the formats enforced on the namespace, kind and
+// release params.
+func TestValidateQuery(t *testing.T) {
+	for _, tc := range []struct {
+		query  string
+		expErr bool
+	}{
+		{query: ""},
+		{query: "namespace=kube-system&kind=Deployment&release=web.v2"},
+		{query: "namespace=default&namespace=team-a"},
+		{query: "namespace="},
+		{query: "limit=a b"},
+		{query: "namespace=team+a", expErr: true},
+		{query: "namespace=team%2Fa", expErr: true},
+		{query: "namespace=Team", expErr: true},
+		{query: "namespace=-team", expErr: true},
+		{query: "namespace=team-", expErr: true},
+		{query: "namespace=default&namespace=a.b", expErr: true},
+		{query: "namespace=" + strings.Repeat("a", 64), expErr: true},
+		{query: "kind=Pod%2Fexec", expErr: true},
+		{query: "kind=1Pod", expErr: true},
+		{query: "release=Web", expErr: true},
+		{query: "release=web..v2", expErr: true},
+		{query: "release=" + strings.Repeat("a", 54), expErr: true},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			query, err := url.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := validateQuery(query); (err != nil) != tc.expErr {
+				t.Errorf("validation error should be %t, got %v", tc.expErr, err)
+			}
+		})
+	}
+}
+
+// TestProxyRejectsInvalidParams checks that malformed params are answered
+// with a 400 without calling the state server.
+func TestProxyRejectsInvalidParams(t *testing.T) {
+	server, mux := newFakeStateServerApp(t, "")
+	for _, path := range []string{"/resources?namespace=a%20b", "/graph?release=web%2Fv2", "/resources?kind=Pod%3B"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s status should be %d, got %d", path, http.StatusBadRequest, rec.Code)
+		}
+	}
+	if n := len(server.received()); n != 0 {
+		t.Errorf("state server should receive no requests, got %d", n)
+	}
+
+	// Params that aren't forwarded, or endpoints that aren't validated, are
+	// left alone
+	for _, path := range []string{"/namespaces?kind=Pod%3B", "/graph?kind=Pod%3B", "/releases?namespace=a%20b"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s status should be %d, got %d", path, http.StatusOK, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package plugin
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket refilled at a fixed rate. Its burst equals
+// one second worth of requests.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	burst := math.Max(1, perSecond)
+	return &rateLimiter{
+		rate:   perSecond,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// allow takes a token if one is available. Otherwise it reports how long it
+// takes until the next token is.
+func (l *rateLimiter) allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// rateLimited rejects requests exceeding the configured rate with a 429
+// before they reach the state server. All routes share the same limiter.
+func (a *App) rateLimited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if a.limiter != nil {
+			if ok, wait := a.limiter.allow(); !ok {
+				retryAfter := int(math.Ceil(wait.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				writeJSONError(w, http.StatusTooManyRequests, "too many requests to astrolabe server")
+				return
+			}
+		}
+		h(w, req)
+	}
+}
@@ -0,0 +1,40 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// TestRateLimit checks that requests beyond the limit are rejected with 429
+// and that the limit is shared by all routes.
+func TestRateLimit(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxRequestsPerSecond":2}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	for i, tc := range []struct {
+		path      string
+		expStatus int
+	}{
+		{path: "/namespaces", expStatus: http.StatusOK},
+		{path: "/graph", expStatus: http.StatusOK},
+		{path: "/releases", expStatus: http.StatusTooManyRequests},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+		if rec.Code != tc.expStatus {
+			t.Errorf("request %d to %s: status should be %d, got %d", i, tc.path, tc.expStatus, rec.Code)
+		}
+		if tc.expStatus == http.StatusTooManyRequests {
+			if retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retryAfter < 1 {
+				t.Errorf("Retry-After should be a positive number of seconds, got %q", rec.Header().Get("Retry-After"))
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	// readinessInterval is how often the state server health is checked
+	// once /ready has been polled.
+	readinessInterval = 10 * time.Second
+
+	// readinessWindow is how recent a successful check must be for the
+	// plugin to be reported ready.
+	readinessWindow = 30 * time.Second
+)
+
+// errNotChecked is reported when no health check has completed yet.
+var errNotChecked = errors.New("state server health not checked yet")
+
+// readinessChecker caches the result of periodic state server health checks
+// so readiness probes don't hit the state server themselves. The checks start
+// with the first call to ready, or right away with poll.
+type readinessChecker struct {
+	interval time.Duration
+	window   time.Duration
+	probe    func(ctx context.Context) error
+	now      func() time.Time
+
+	// ctx bounds the background checks and is cancelled on Dispose.
+	ctx   context.Context
+	start sync.Once
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func newReadinessChecker(ctx context.Context, probe func(context.Context) error) *readinessChecker {
+	return &readinessChecker{
+		interval: readinessInterval,
+		window:   readinessWindow,
+		probe:    probe,
+		now:      time.Now,
+		ctx:      ctx,
+		err:      errNotChecked,
+	}
+}
+
+// ready returns nil if the last health check succeeded within the window.
+// The first call checks synchronously and starts the background checks.
+func (c *readinessChecker) ready() error {
+	c.start.Do(func() {
+		c.check()
+		go c.run()
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	if age := c.now().Sub(c.checked); age >= c.window {
+		return fmt.Errorf("last state server health check is %s old", age.Round(time.Second))
+	}
+	return nil
+}
+
+// poll starts the background checks every interval without waiting for
+// ready to be called. The window is widened to cover a few intervals so a
+// slow poll doesn't report the plugin unready between checks.
+func (c *readinessChecker) poll(interval time.Duration) {
+	c.interval = interval
+	c.window = max(c.window, 3*interval)
+	c.start.Do(func() {
+		go func() {
+			c.check()
+			c.run()
+		}()
+	})
+}
+
+// check probes the state server and records the result, logging when the
+// state server becomes reachable or unreachable.
+func (c *readinessChecker) check() {
+	err := c.probe(c.ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case err != nil && (c.err == nil || c.err == errNotChecked):
+		log.DefaultLogger.Warn("State server is unreachable", "error", err)
+	case err != nil:
+		log.DefaultLogger.Debug("State server health check failed", "error", err)
+	case c.err != nil:
+		log.DefaultLogger.Info("State server is reachable")
+	}
+	c.checked = c.now()
+	c.err = err
+}
+
+// run checks the state server every interval until the checker's context is
+// cancelled.
+func (c *readinessChecker) run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.check()
+		}
+	}
+}
+
+// probeHealth calls the health probe of the default state server.
+func (a *App) probeHealth(ctx context.Context) error {
+	target, err := a.settings.resolve(a.settings.indexerURL, a.settings.apiPath("healthz", ""))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	a.setUpstreamHeaders(req)
+	a.authorize(req)
+
+	resp, err := a.healthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("astrolabe server returned %s", resp.Status)
+	}
+	return nil
+}
+
+// handleLive is the liveness probe: it succeeds as long as the plugin backend
+// is able to serve requests at all.
+func (a *App) handleLive(w http.ResponseWriter, req *http.Request) {
+	writeStatus(w, "live")
+}
+
+// handleReady is the readiness probe: it succeeds only if the state server was
+// found healthy within readinessWindow. Results come from background checks,
+// so it is cheap to poll.
+func (a *App) handleReady(w http.ResponseWriter, req *http.Request) {
+	if a.settingsErr != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, a.settingsErr.Error())
+		return
+	}
+	if err := a.readiness.ready(); err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeStatus(w, "ready")
+}
+
+// writeStatus writes a {"status": status} body for the probe endpoints.
+func writeStatus(w http.ResponseWriter, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, `{"status":%q}`, status); err != nil {
+		log.DefaultLogger.Error("Failed to write probe response", "error", err)
+	}
+}
@@ -0,0 +1,133 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestReadinessChecker checks that only recent successful checks count as
+// ready.
+func TestReadinessChecker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var probeErr error
+	now := time.Now()
+	c := newReadinessChecker(ctx, func(context.Context) error { return probeErr })
+	c.interval = time.Hour
+	c.now = func() time.Time { return now }
+
+	if err := c.ready(); err != nil {
+		t.Errorf("should be ready after a successful check, got %v", err)
+	}
+
+	now = now.Add(c.window)
+	if err := c.ready(); err == nil {
+		t.Error("should not be ready once the last check is older than the window")
+	}
+
+	c.check()
+	if err := c.ready(); err != nil {
+		t.Errorf("should be ready after a new successful check, got %v", err)
+	}
+
+	probeErr = errors.New("connection refused")
+	c.check()
+	if err := c.ready(); !errors.Is(err, probeErr) {
+		t.Errorf("should report the failed check, got %v", err)
+	}
+}
+
+// TestReadinessPolling checks that poll checks the state server from the start
+// and keeps the result current without ready being called.
+func TestReadinessPolling(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var probes atomic.Int32
+	c := newReadinessChecker(ctx, func(context.Context) error {
+		if probes.Add(1) > 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	c.poll(10 * time.Millisecond)
+	if c.window != readinessWindow {
+		t.Errorf("window should stay %s for short intervals, got %s", readinessWindow, c.window)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for probes.Load() < 4 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := probes.Load(); n < 4 {
+		t.Fatalf("state server should be polled repeatedly, got %d probes", n)
+	}
+	if err := c.ready(); err == nil {
+		t.Error("should not be ready once the polled checks fail")
+	}
+
+	slow := newReadinessChecker(ctx, func(context.Context) error { return nil })
+	slow.poll(time.Minute)
+	if slow.window != 3*time.Minute {
+		t.Errorf("window should cover three poll intervals, got %s", slow.window)
+	}
+}
+
+// TestProbes checks /live and /ready against healthy and unreachable state
+// servers.
+func TestProbes(t *testing.T) {
+	var probes atomic.Int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		if r.URL.Path != "/api/v1/healthz" {
+			t.Errorf("probe path should be /api/v1/healthz, got %q", r.URL.Path)
+		}
+	}))
+	defer healthy.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	for _, tc := range []struct {
+		name      string
+		jsonData  string
+		expReady  int
+		expProbes int32
+	}{
+		{name: "healthy", jsonData: `{"indexerUrl":"` + healthy.URL + `"}`, expReady: http.StatusOK, expProbes: 1},
+		{name: "unreachable", jsonData: `{"indexerUrl":"` + unreachable.URL + `"}`, expReady: http.StatusServiceUnavailable},
+		{name: "invalid settings", jsonData: `{"indexerUrl":"ftp://astrolabe"}`, expReady: http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			probes.Store(0)
+			app := newTestApp(t, tc.jsonData)
+			mux := http.NewServeMux()
+			if err := app.registerRoutes(mux); err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/live", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("/live status should be 200, got %d", rec.Code)
+			}
+
+			// Further polls are served from the cached result
+			for i := range 3 {
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+				if rec.Code != tc.expReady {
+					t.Errorf("poll %d: /ready status should be %d, got %d", i, tc.expReady, rec.Code)
+				}
+			}
+			if got := probes.Load(); got != tc.expProbes {
+				t.Errorf("state server should be probed %d times, got %d", tc.expProbes, got)
+			}
+		})
+	}
+}
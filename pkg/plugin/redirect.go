@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	// pluginID is the ID from plugin.json, used when the request context
+	// doesn't carry the plugin config.
+	pluginID = "astrolabe-astrolabe-app"
+
+	// maxRedirects is how many redirects redirectTransport follows for a
+	// single request.
+	maxRedirects = 10
+)
+
+// isRedirect reports whether a response redirects to its Location.
+func isRedirect(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return resp.Header.Get("Location") != ""
+	}
+	return false
+}
+
+// redirectLocation resolves the Location of a redirect against the request it
+// answers. ok is false if it isn't a valid URL.
+func redirectLocation(resp *http.Response) (*url.URL, bool) {
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	return location, err == nil
+}
+
+// rewriteLocation points the Location of an upstream redirect within the
+// state server API back at the plugin's resource routes, e.g.
+// http://astrolabe:8080/api/v1/graph becomes
+// /api/plugins/<plugin ID>/resources/graph, so browsers follow it through
+// Grafana. Redirects elsewhere are relayed as is.
+func (a *App) rewriteLocation(resp *http.Response) {
+	if !isRedirect(resp) {
+		return
+	}
+	location, ok := redirectLocation(resp)
+	if !ok || location.Scheme != resp.Request.URL.Scheme || location.Host != resp.Request.URL.Host {
+		return
+	}
+	prefix := a.settings.upstreamPath("/api/" + url.PathEscape(a.settings.APIVersion))
+	route, ok := strings.CutPrefix(location.EscapedPath(), prefix)
+	if !ok || (route != "" && !strings.HasPrefix(route, "/")) {
+		return
+	}
+
+	id := backend.PluginConfigFromContext(resp.Request.Context()).PluginID
+	if id == "" {
+		id = pluginID
+	}
+	rewritten := "/api/plugins/" + url.PathEscape(id) + "/resources" + a.settings.RoutePrefix + route
+	if location.RawQuery != "" {
+		rewritten += "?" + location.RawQuery
+	}
+	resp.Header.Set("Location", rewritten)
+}
+
+// redirectTransport follows the redirects of idempotent requests to the same
+// state server, so clients only see the final response. Redirects to other
+// hosts are returned as is since they must not receive our credentials.
+type redirectTransport struct {
+	next http.RoundTripper
+}
+
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if !isIdempotent(req.Method) {
+		return resp, err
+	}
+	for hops := 0; err == nil && hops < maxRedirects && isRedirect(resp); hops++ {
+		location, ok := redirectLocation(resp)
+		if !ok || location.Scheme != req.URL.Scheme || location.Host != req.URL.Host {
+			break
+		}
+		log.DefaultLogger.Debug("Following upstream redirect", "status", resp.StatusCode, "location", location.Redacted())
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		next := req.Clone(req.Context())
+		next.URL = location
+		resp, err = t.next.RoundTrip(next)
+	}
+	return resp, err
+}
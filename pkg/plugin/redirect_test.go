@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestProxyRedirects checks that upstream redirects are either rewritten to
+// the plugin routes or followed, depending on followRedirects.
+func TestProxyRedirects(t *testing.T) {
+	var indexerURL string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/resources/old":
+			w.Header().Set("Location", indexerURL+"/api/v1/resources/new?full=true")
+			w.WriteHeader(http.StatusMovedPermanently)
+		case "/api/v1/resources/moved":
+			w.Header().Set("Location", "/api/v1/resources/old")
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		case "/api/v1/resources/new":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"uid":"new"}`))
+		case "/api/v1/resources/external":
+			w.Header().Set("Location", "https://docs.example.com/astrolabe")
+			w.WriteHeader(http.StatusFound)
+		case "/api/v1/resources/internal":
+			w.Header().Set("Location", indexerURL+"/login")
+			w.WriteHeader(http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer indexer.Close()
+	indexerURL = indexer.URL
+
+	for _, tc := range []struct {
+		name        string
+		follow      bool
+		uid         string
+		expStatus   int
+		expLocation string
+		expBody     string
+	}{
+		{name: "absolute location is rewritten", uid: "old", expStatus: http.StatusMovedPermanently, expLocation: "/api/plugins/astrolabe-astrolabe-app/resources/resources/new?full=true"},
+		{name: "relative location is rewritten", uid: "moved", expStatus: http.StatusTemporaryRedirect, expLocation: "/api/plugins/astrolabe-astrolabe-app/resources/resources/old"},
+		{name: "external location is kept", uid: "external", expStatus: http.StatusFound, expLocation: "https://docs.example.com/astrolabe"},
+		{name: "location outside the API is kept", uid: "internal", expStatus: http.StatusFound, expLocation: indexer.URL + "/login"},
+		{name: "redirects are followed", follow: true, uid: "moved", expStatus: http.StatusOK, expBody: `{"uid":"new"}`},
+		{name: "external redirect is not followed", follow: true, uid: "external", expStatus: http.StatusFound, expLocation: "https://docs.example.com/astrolabe"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			jsonData := `{"indexerUrl":"` + indexer.URL + `"}`
+			if tc.follow {
+				jsonData = `{"indexerUrl":"` + indexer.URL + `","followRedirects":true}`
+			}
+			app := newTestApp(t, jsonData)
+
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/resources/"+tc.uid, nil), "resource", tc.uid)
+			if rec.Code != tc.expStatus {
+				t.Errorf("status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			if location := rec.Header().Get("Location"); location != tc.expLocation {
+				t.Errorf("location should be %q, got %q", tc.expLocation, location)
+			}
+			if tc.expBody != "" && rec.Body.String() != tc.expBody {
+				t.Errorf("body should be %s, got %s", tc.expBody, rec.Body)
+			}
+		})
+	}
+}
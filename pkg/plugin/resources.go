@@ -1,114 +1,852 @@
 package plugin
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// proxyToIndexer forwards requests to the astrolabe server
-func (a *App) proxyToIndexer(w http.ResponseWriter, req *http.Request, path string) {
+// Headers set by the proxy on upstream requests.
+const (
+	// requestIDHeader carries the ID used to correlate a proxied request.
+	requestIDHeader = "X-Request-ID"
+
+	// grafanaUserHeader and grafanaOrgIDHeader identify the Grafana user on
+	// whose behalf a request is made.
+	grafanaUserHeader  = "X-Grafana-User"
+	grafanaOrgIDHeader = "X-Grafana-Org-Id"
+)
+
+// upstreamDurationHeader is set on proxied responses and reports how long the
+// state server took to respond, in milliseconds.
+const upstreamDurationHeader = "X-Upstream-Duration-Ms"
+
+// proxyToIndexer forwards requests to the astrolabe server, sending them to
+// the path of endpoint in apiPaths. name fills in the {name} placeholder
+// of endpoints addressing a single object and is empty otherwise.
+func (a *App) proxyToIndexer(w http.ResponseWriter, req *http.Request, endpoint, name string) {
+	proxyRequestsInFlight.Inc()
+	defer proxyRequestsInFlight.Dec()
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		proxyRequestsTotal.WithLabelValues(endpoint, strconv.Itoa(rec.status)).Inc()
+	}()
+	w = rec
+
+	// unwrap=<field> extracts the payload of an enveloped JSON response and
+	// pretty=true re-indents it for reading in a terminal. Those responses
+	// are buffered and asked for uncompressed, which is also how they are
+	// cached; all others are relayed as they arrive.
+	if rewrites := a.responseRewrites(req); len(rewrites) > 0 && !streamingEndpoints[endpoint] {
+		rw := newRewriteWriter(w, rewrites...)
+		defer rw.finish()
+		w = rw
+		req = req.Clone(req.Context())
+		req.Header.Del("Accept-Encoding")
+	}
+
+	if !a.track() {
+		writeJSONError(w, http.StatusServiceUnavailable, "plugin is shutting down")
+		return
+	}
+	defer a.inFlight.Done()
+
+	if a.settingsErr != nil {
+		writeJSONError(w, http.StatusInternalServerError, a.settingsErr.Error())
+		return
+	}
+	a.waitReady(req.Context())
+
 	// Get indexer URL from plugin settings
-	indexerURL := a.getIndexerURL(req)
+	indexerURL, err := a.getIndexerURL(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	// Build target URL
-	targetURL := fmt.Sprintf("%s%s", indexerURL, path)
-	if req.URL.RawQuery != "" {
-		targetURL = fmt.Sprintf("%s?%s", targetURL, req.URL.RawQuery)
+	target, err := a.settings.resolve(indexerURL, a.settings.apiPath(endpoint, name))
+	if err != nil {
+		log.DefaultLogger.Error("Failed to build target URL", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	log.DefaultLogger.Debug("Proxying request", "target", targetURL)
+	// Correlate this request across our logs and the state server's
+	requestID := req.Header.Get(requestIDHeader)
+	if requestID == "" {
+		requestID = uuid.NewString()
+		req.Header.Set(requestIDHeader, requestID)
+	}
+	logger := log.DefaultLogger.With("requestID", requestID)
+	start := time.Now()
 
-	// Create new request
-	proxyReq, err := http.NewRequest(req.Method, targetURL, req.Body)
-	if err != nil {
-		log.DefaultLogger.Error("Failed to create proxy request", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	query, dropped := filterQuery(endpoint, req.URL.RawQuery)
+	if len(dropped) > 0 {
+		logger.Debug("Dropped query params not allowed upstream", "endpoint", endpoint, "params", dropped)
+	}
+	// Malformed names are rejected rather than confusing the state server.
+	// Only the params actually forwarded are checked.
+	if validatedEndpoints[endpoint] {
+		values, _ := url.ParseQuery(query)
+		if err := validateQuery(values); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	query = withDefaultNamespace(endpoint, query, a.settings.DefaultNamespace)
+
+	target.RawQuery = query
+
+	if a.settings.Protocol == protocolGRPCWeb && grpcWebMethods[endpoint] != "" {
+		a.proxyGRPCWeb(w, req, endpoint, indexerURL, query, logger)
+		return
+	}
+
+	// Only a sample of the requests is logged in busy clusters. Failures are
+	// always logged.
+	sampled := a.logSampler.sample()
+	if sampled {
+		logger.Info("Proxying request", "method", req.Method, "target", target.String())
+	}
+
+	// The proxied endpoints are read-only, so there is no reason to relay a
+	// large body upstream
+	if req.ContentLength > a.settings.MaxRequestBodyBytes {
+		writeBodyTooLarge(w, a.settings.MaxRequestBodyBytes)
 		return
 	}
+	if req.Body != nil {
+		req.Body = http.MaxBytesReader(w, req.Body, a.settings.MaxRequestBodyBytes)
+	}
+
+	// Buffer the body of retryable requests so it can be replayed
+	if isIdempotent(req.Method) && req.Body != nil {
+		buf, err := io.ReadAll(req.Body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeBodyTooLarge(w, maxBytesErr.Limit)
+				return
+			}
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(buf))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(buf)), nil
+		}
+	}
+
+	clientAcceptsGzip := acceptsGzip(req.Header)
+
+	// Resource lists are filtered by kind and graphs by release in the plugin
+	// on request, see handleResources and handleGraph
+	serverSideFilter := req.URL.Query().Get("serverSideFilter") == "true"
+	var filterKind, filterRelease string
+	if serverSideFilter {
+		switch endpoint {
+		case "resources", "release_resources":
+			filterKind = req.URL.Query().Get("kind")
+		case "graph":
+			filterRelease = req.URL.Query().Get("release")
+		}
+	}
+
+	// Serve rarely changing lists from the cache when enabled. The indexer
+	// is part of the key since the cluster param is not forwarded, and so is
+	// the encoding since the body differs with and without gzip. The lists
+	// may be filtered for the user and org forwarded upstream, so entries
+	// are kept per identity. Requests that are already conditional are left
+	// to the client and upstream.
+	identity := identityFromContext(req.Context())
+	var cacheKey string
+	var stale *cacheEntry
+	if a.cache != nil && req.Method == http.MethodGet && cachedEndpoints[endpoint] && !isConditional(req) {
+		cacheKey = fmt.Sprintf("%s gzip=%t", identity.key(target), clientAcceptsGzip)
+		if entry, fresh, ok := a.cache.get(cacheKey); ok {
+			if fresh {
+				entry.serve(w)
+				return
+			}
+			stale = &entry
+		}
+	}
+
+	// Graphs are served from the background-refreshed cache once fetched.
+	// The first fetch is stored uncompressed so it can serve any client.
+	// The cache holds the graphs as returned by the state server, so graphs
+	// filtered by the plugin are always fetched. The state server may tailor
+	// the graph to the user and org forwarded upstream, so entries are kept
+	// per identity.
+	cacheGraph := a.graphCache != nil && req.Method == http.MethodGet && endpoint == "graph" && !isConditional(req) && filterRelease == ""
+	if cacheGraph {
+		if entry, age, ok := a.graphCache.get(target, identity); ok {
+			entry.serve(w, age, a.graphCache.ttl)
+			return
+		}
+	}
 
-	// Copy headers
-	for key, values := range req.Header {
-		for _, value := range values {
-			proxyReq.Header.Add(key, value)
+	// Continue the trace of the client, e.g. started in the browser, unless
+	// Grafana already passed one down
+	parent := req.Context()
+	if !trace.SpanContextFromContext(parent).IsValid() {
+		parent = otel.GetTextMapPropagator().Extract(parent, propagation.HeaderCarrier(req.Header))
+	}
+	ctx, span := tracing.DefaultTracer().Start(parent, "proxy "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", target.String()),
+		),
+	)
+	defer span.End()
+
+	// Event streams stay open for as long as the client listens, so they are
+	// only cancelled along with the client request
+	streaming := streamingEndpoints[endpoint]
+	var cancel context.CancelFunc
+	if streaming {
+		ctx, cancel = context.WithCancel(withoutUpstreamSlot(ctx))
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, a.settings.endpointTimeout(endpoint))
+	}
+	defer cancel()
+
+	proxy := &httputil.ReverseProxy{
+		Director: func(out *http.Request) {
+			u := *target
+			out.URL = &u
+			out.Host = target.Host
+
+			// Always ask upstream for compression; if the client can't take
+			// gzip the response is decompressed in ModifyResponse. Streams are
+			// left uncompressed so every event can be relayed as it arrives.
+			if out.Header.Get("Accept-Encoding") == "" && !streaming {
+				out.Header.Set("Accept-Encoding", "gzip")
+			}
+
+			filterCookies(out.Header, a.settings.ForwardCookies)
+
+			// The configured headers go first so that the ones set by the
+			// plugin take precedence
+			a.setUpstreamHeaders(out)
+			a.authorize(out)
+			setIdentityHeaders(out)
+			if stale != nil {
+				stale.setConditional(out)
+			}
+
+			// Continue our span upstream so the state server nests under it
+			preserveTraceHeaders(out.Header)
+			otel.GetTextMapPropagator().Inject(out.Context(), propagation.HeaderCarrier(out.Header))
+		},
+		Transport: a.transport,
+		ModifyResponse: func(resp *http.Response) error {
+			filterResponseHeaders(resp.Header, a.settings.responseHeaders)
+			duration := time.Since(start)
+			proxyUpstreamDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+			if sampled || resp.StatusCode >= http.StatusInternalServerError {
+				logger.Info("Upstream request completed", "status", resp.StatusCode, "duration", duration)
+			}
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+			if resp.StatusCode >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, resp.Status)
+			}
+			// Set once the response is final since a revalidated response
+			// gets the cached headers
+			defer func() {
+				resp.Header.Set(upstreamDurationHeader, strconv.FormatInt(duration.Milliseconds(), 10))
+			}()
+			a.rewriteLocation(resp)
+			// HEAD responses only carry the status and headers; there is no
+			// body to decompress, wrap or cache
+			if req.Method == http.MethodHead {
+				resp.Body.Close()
+				resp.Body = http.NoBody
+				return nil
+			}
+			if stale != nil && resp.StatusCode == http.StatusNotModified {
+				a.cache.renew(cacheKey)
+				stale.replace(resp)
+				return nil
+			}
+			if (!clientAcceptsGzip || cacheGraph) && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+				if err := decompressResponse(resp); err != nil {
+					return err
+				}
+			}
+			// Streams are long-lived, so only the other responses are capped
+			if !streaming {
+				if err := limitResponse(resp, a.settings.MaxResponseBytes); err != nil {
+					return err
+				}
+			}
+			if endpoint == "kinds" && resp.StatusCode == http.StatusNotFound {
+				replaceBody(resp, http.StatusOK, []byte("[]"))
+			}
+			if err := wrapNonJSONError(resp); err != nil {
+				return err
+			}
+			if filterKind != "" {
+				if err := rewriteJSONBody(resp, filterByKind(filterKind)); err != nil {
+					return err
+				}
+			}
+			if filterRelease != "" {
+				if err := rewriteJSONBody(resp, filterGraphByRelease(filterRelease)); err != nil {
+					return err
+				}
+			}
+			if s, ok := responseSchemas[endpoint]; ok && a.settings.ValidateResponses {
+				if err := rewriteJSONBody(resp, validateResponse(endpoint, s)); err != nil {
+					return err
+				}
+			}
+			if cacheGraph {
+				resp.Header.Set("X-Cache", "MISS")
+				if resp.StatusCode == http.StatusOK {
+					header := http.Header{}
+					if ct := resp.Header.Get("Content-Type"); ct != "" {
+						header.Set("Content-Type", ct)
+					}
+					resp.Body = &cachingBody{ReadCloser: resp.Body, store: func(body []byte) {
+						a.graphCache.set(target, identity, header, bytes.Clone(body))
+					}}
+				}
+			}
+			if cacheKey != "" {
+				resp.Header.Set("X-Cache", "MISS")
+				if resp.StatusCode == http.StatusOK {
+					header := resp.Header.Clone()
+					resp.Body = &cachingBody{ReadCloser: resp.Body, store: func(body []byte) {
+						a.cache.set(cacheKey, header, bytes.Clone(body))
+					}}
+				}
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			duration := time.Since(start)
+			proxyUpstreamDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+			logger.Error("Failed to proxy request", "error", err, "duration", duration)
+			_ = tracing.Error(span, err)
+			writeProxyError(w, indexerURL, err)
+		},
+	}
+
+	serve := func(w http.ResponseWriter) {
+		proxy.ServeHTTP(w, req.WithContext(ctx))
+	}
+
+	// Identical GET requests made at the same time, e.g. by the panels of a
+	// dashboard loading together, share one upstream call. If the client
+	// making that call goes away, the others make their own.
+	if req.Method == http.MethodGet && !streaming {
+		v, _, _ := a.flights.Do(dedupKey(req, target, clientAcceptsGzip, serverSideFilter), func() (any, error) {
+			shared := newSharedResponse()
+			serve(shared)
+			shared.cancelled = errors.Is(ctx.Err(), context.Canceled)
+			return shared, nil
+		})
+		if shared := v.(*sharedResponse); !shared.cancelled || req.Context().Err() != nil {
+			shared.replay(w, requestID)
+			return
 		}
 	}
+	serve(w)
+}
+
+// responseRewrites returns the rewrites of a JSON response asked for by the
+// pretty and unwrap params. Without an unwrap param the unwrapField setting
+// applies; an empty one disables it.
+func (a *App) responseRewrites(req *http.Request) []func([]byte) ([]byte, error) {
+	query := req.URL.Query()
+	var rewrites []func([]byte) ([]byte, error)
+	field := query.Get("unwrap")
+	if !query.Has("unwrap") && a.settings != nil {
+		field = a.settings.UnwrapField
+	}
+	if field != "" {
+		rewrites = append(rewrites, unwrapJSON(field))
+	}
+	if query.Get("pretty") == "true" {
+		rewrites = append(rewrites, indentJSON)
+	}
+	return rewrites
+}
+
+// writeProxyError reports a failed call to the state server at indexerURL as
+// a JSON error.
+func writeProxyError(w http.ResponseWriter, indexerURL *url.URL, err error) {
+	var netErr net.Error
+	var maxBytesErr *http.MaxBytesError
+	var tooLargeErr *responseTooLargeError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		writeUpstreamTimeout(w, indexerURL, err)
+	case errors.As(err, &maxBytesErr):
+		writeBodyTooLarge(w, maxBytesErr.Limit)
+	case errors.As(err, &tooLargeErr):
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+	case errors.Is(err, errCircuitOpen), errors.Is(err, errTooManyUpstream):
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, errInvalidUpstreamResponse):
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+	default:
+		writeUpstreamUnreachable(w, indexerURL, err)
+	}
+}
+
+// setUpstreamHeaders adds the configured static headers to an upstream
+// request.
+func (a *App) setUpstreamHeaders(req *http.Request) {
+	for name, values := range a.settings.upstreamHeaders {
+		req.Header[name] = values
+	}
+}
 
-	// Make request
-	client := &http.Client{}
-	resp, err := client.Do(proxyReq)
+// authorize attaches the configured credentials to an upstream request. A
+// configured token always wins over whatever the client sent, and over basic
+// auth credentials if both are set.
+func (a *App) authorize(req *http.Request) {
+	switch {
+	case a.settings.IndexerToken != "":
+		req.Header.Set("Authorization", "Bearer "+a.settings.IndexerToken)
+	case a.settings.IndexerUsername != "":
+		req.SetBasicAuth(a.settings.IndexerUsername, a.settings.IndexerPassword)
+	}
+}
+
+// fetch makes a GET request to an endpoint of the state server on behalf of
+// the plugin and returns the uncompressed body of a 200 response, along with
+// its Content-Type and X-Continue-Token headers.
+func (a *App) fetch(ctx context.Context, endpoint string, target *url.URL) (http.Header, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.settings.endpointTimeout(endpoint))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
 	if err != nil {
-		log.DefaultLogger.Error("Failed to proxy request", "error", err)
-		http.Error(w, fmt.Sprintf("Failed to connect to astrolabe server: %v", err), http.StatusBadGateway)
-		return
+		return nil, nil, err
+	}
+	a.setUpstreamHeaders(req)
+	a.authorize(req)
+	setIdentityHeaders(req)
+	if cookie, _ := ctx.Value(cookiesKey{}).(string); cookie != "" {
+		req.Header.Set("Cookie", cookie)
+	}
+
+	resp, err := a.transport.RoundTrip(req)
+	if err != nil {
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
-	// Copy response headers
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("astrolabe server returned %s", resp.Status)
+	}
+	if err := limitResponse(resp, a.settings.MaxResponseBytes); err != nil {
+		return nil, nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := http.Header{}
+	for _, key := range []string{"Content-Type", "X-Continue-Token"} {
+		if value := resp.Header.Get(key); value != "" {
+			header.Set(key, value)
 		}
 	}
+	return header, body, nil
+}
 
-	// Copy status code
-	w.WriteHeader(resp.StatusCode)
+// traceHeaders are the W3C trace context and baggage headers, which are
+// always forwarded end to end.
+var traceHeaders = []string{"Traceparent", "Tracestate", "Baggage"}
 
-	// Copy response body
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		log.DefaultLogger.Error("Failed to copy response body", "error", err)
+// preserveTraceHeaders keeps the trace headers from being dropped as
+// hop-by-hop headers by removing them from the Connection header.
+func preserveTraceHeaders(h http.Header) {
+	values := h.Values("Connection")
+	if len(values) == 0 {
+		return
+	}
+	var kept []string
+	for _, value := range values {
+		for _, token := range strings.Split(value, ",") {
+			token = strings.TrimSpace(token)
+			if token != "" && !slices.Contains(traceHeaders, http.CanonicalHeaderKey(token)) {
+				kept = append(kept, token)
+			}
+		}
+	}
+	h.Del("Connection")
+	if len(kept) > 0 {
+		h.Set("Connection", strings.Join(kept, ", "))
+	}
+}
+
+// setIdentityHeaders tells the state server which Grafana user and org the
+// request is made on behalf of. The values come from the plugin context set by
+// Grafana; headers of the same name sent by the client are dropped so they
+// can't be spoofed.
+func setIdentityHeaders(req *http.Request) {
+	req.Header.Del(grafanaUserHeader)
+	req.Header.Del(grafanaOrgIDHeader)
+
+	if user := backend.UserFromContext(req.Context()); user != nil && user.Login != "" {
+		req.Header.Set(grafanaUserHeader, user.Login)
+	}
+	if orgID := backend.PluginConfigFromContext(req.Context()).OrgID; orgID != 0 {
+		req.Header.Set(grafanaOrgIDHeader, strconv.FormatInt(orgID, 10))
+	}
+}
+
+// filterCookies drops the cookies not listed in allowed from the Cookie
+// headers, so that Grafana's own cookies don't leak upstream. Malformed
+// headers are dropped entirely.
+func filterCookies(h http.Header, allowed []string) {
+	var kept []string
+	for _, line := range h.Values("Cookie") {
+		cookies, err := http.ParseCookie(line)
+		if err != nil {
+			continue
+		}
+		for _, cookie := range cookies {
+			if slices.Contains(allowed, cookie.Name) {
+				kept = append(kept, cookie.String())
+			}
+		}
+	}
+	h.Del("Cookie")
+	if len(kept) > 0 {
+		h.Set("Cookie", strings.Join(kept, "; "))
 	}
 }
 
-// getIndexerURL gets the indexer URL from plugin settings
-func (a *App) getIndexerURL(req *http.Request) string {
-	// Try to get from plugin context/settings
-	// For now, use default or environment variable
-	indexerURL := "http://astrolabe:8080"
+// cookiesKey is the context key of the allowed cookies of a request, for the
+// calls the plugin makes to the state server on its behalf.
+type cookiesKey struct{}
+
+// forwardCookies keeps the allowed cookies of the request in its context, so
+// that the calls made with fetch, e.g. by /overview, carry them like the
+// proxied ones.
+func (a *App) forwardCookies(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if a.settings != nil && len(a.settings.ForwardCookies) > 0 {
+			cookies := http.Header{"Cookie": req.Header.Values("Cookie")}
+			filterCookies(cookies, a.settings.ForwardCookies)
+			if cookie := cookies.Get("Cookie"); cookie != "" {
+				req = req.WithContext(context.WithValue(req.Context(), cookiesKey{}, cookie))
+			}
+		}
+		h(w, req)
+	}
+}
 
-	// TODO: Get from plugin settings when available
-	// This would come from the AppConfig jsonData
+// getIndexerURL gets the indexer URL from plugin settings. A "cluster" query
+// parameter selects one of the configured clusters; without it the primary
+// indexer URL is used.
+func (a *App) getIndexerURL(req *http.Request) (*url.URL, error) {
+	cluster := req.URL.Query().Get("cluster")
+	if cluster == "" {
+		return a.settings.indexerURL, nil
+	}
 
-	return indexerURL
+	indexerURL, ok := a.settings.clusterURLs[cluster]
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster %q", cluster)
+	}
+	return indexerURL, nil
 }
 
 // Handler functions for each endpoint
 func (a *App) handleNamespaces(w http.ResponseWriter, req *http.Request) {
-	a.proxyToIndexer(w, req, "/api/v1/namespaces")
+	a.proxyToIndexer(w, req, "namespaces", "")
 }
 
 func (a *App) handleReleases(w http.ResponseWriter, req *http.Request) {
-	a.proxyToIndexer(w, req, "/api/v1/releases")
+	a.proxyToIndexer(w, req, "releases", "")
 }
 
+// handleGraph proxies the graph. With format=nodegraph it is converted to the
+// frames of Grafana's Node Graph panel and with format=dot, or an Accept header
+// asking for text/vnd.graphviz, to Graphviz DOT. Otherwise it is passed
+// through as is. cluster=all merges the graphs of every cluster, see
+// serveAllClusters.
+//
+// The release query param scopes the graph to a Helm release and is
+// forwarded for the state server to filter on. For state servers that ignore
+// it, serverSideFilter=true makes the plugin drop the nodes of other releases
+// and the edges touching them itself.
 func (a *App) handleGraph(w http.ResponseWriter, req *http.Request) {
-	a.proxyToIndexer(w, req, "/api/v1/graph")
+	switch format := req.URL.Query().Get("format"); {
+	case format == "nodegraph":
+		a.serveGraphAs(w, req, toNodeGraph, "application/json")
+	case format == "dot" || format == "" && acceptsDOT(req.Header):
+		a.serveGraphAs(w, req, toDOT, dotContentType)
+	default:
+		a.proxyGraph(w, req)
+	}
+}
+
+// handleGraphNodes and handleGraphEdges proxy the graph split into its nodes
+// and edges, so large graphs can be loaded page by page. They paginate like
+// handleResources.
+func (a *App) handleGraphNodes(w http.ResponseWriter, req *http.Request) {
+	a.proxyToIndexer(w, req, "graph_nodes", "")
 }
 
+func (a *App) handleGraphEdges(w http.ResponseWriter, req *http.Request) {
+	a.proxyToIndexer(w, req, "graph_edges", "")
+}
+
+// handleGraphStream proxies the state server's graph updates as Server-Sent
+// Events. The reverse proxy flushes text/event-stream responses after every
+// write, so events reach the client as they arrive.
+func (a *App) handleGraphStream(w http.ResponseWriter, req *http.Request) {
+	a.proxyToIndexer(w, req, "graph_stream", "")
+}
+
+// handleResources proxies the resource list. Pagination is done by the state
+// server: the limit and continue query params are forwarded as is and the
+// X-Continue-Token response header is relayed back for the next page.
+//
+// The kind query param is forwarded for the state server to filter on. For
+// state servers that ignore it, serverSideFilter=true makes the plugin filter
+// the returned list by kind itself; the param is not forwarded.
 func (a *App) handleResources(w http.ResponseWriter, req *http.Request) {
-	a.proxyToIndexer(w, req, "/api/v1/resources")
+	a.proxyToIndexer(w, req, "resources", "")
+}
+
+// handleResource proxies a single resource looked up by its UID, taken from
+// the path after /resources/.
+func (a *App) handleResource(w http.ResponseWriter, req *http.Request) {
+	uid := strings.TrimPrefix(req.URL.Path, "/resources/")
+	if uid == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing resource UID")
+		return
+	}
+	a.proxyToIndexer(w, req, "resource", uid)
+}
+
+// handleReleaseResources proxies the resources of a single release, matching
+// /releases/{name}/resources.
+func (a *App) handleReleaseResources(w http.ResponseWriter, req *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(req.URL.Path, "/releases/"), "/resources")
+	if !ok || strings.Contains(name, "/") {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing release name")
+		return
+	}
+	a.proxyToIndexer(w, req, "release_resources", name)
+}
+
+func (a *App) handleWorkloads(w http.ResponseWriter, req *http.Request) {
+	a.proxyToIndexer(w, req, "workloads", "")
+}
+
+func (a *App) handleEvents(w http.ResponseWriter, req *http.Request) {
+	a.proxyToIndexer(w, req, "events", "")
+}
+
+// handleKinds proxies the resource kinds indexed by the state server,
+// optionally scoped to a namespace. State servers predating the endpoint
+// answer with 404, which is turned into an empty list.
+func (a *App) handleKinds(w http.ResponseWriter, req *http.Request) {
+	a.proxyToIndexer(w, req, "kinds", "")
+}
+
+// handleStateServerMetrics proxies the state server's own Prometheus metrics
+// so they can be scraped through Grafana. The text exposition format is
+// passed through unmodified and the Accept header is forwarded for content
+// negotiation.
+func (a *App) handleStateServerMetrics(w http.ResponseWriter, req *http.Request) {
+	a.proxyToIndexer(w, req, "state_metrics", "")
+}
+
+// handleHealthz proxies the state server's health probe and relays its status.
+// Unlike the data endpoints it is never retried and uses healthzTimeout.
+func (a *App) handleHealthz(w http.ResponseWriter, req *http.Request) {
+	if a.settingsErr != nil {
+		writeJSONError(w, http.StatusInternalServerError, a.settingsErr.Error())
+		return
+	}
+	a.waitReady(req.Context())
+
+	indexerURL, err := a.getIndexerURL(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	target, err := a.settings.resolve(indexerURL, a.settings.apiPath("healthz", ""))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	probe, err := http.NewRequestWithContext(req.Context(), http.MethodGet, target.String(), nil)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.setUpstreamHeaders(probe)
+	a.authorize(probe)
+
+	resp, err := a.healthClient.Do(probe)
+	if err != nil {
+		log.DefaultLogger.Error("Health probe failed", "error", err)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			writeJSONError(w, http.StatusGatewayTimeout, "astrolabe server did not respond in time")
+			return
+		}
+		writeUpstreamUnreachable(w, indexerURL, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.DefaultLogger.Error("Failed to copy response body", "error", err)
+	}
 }
 
 // handlePing is an example HTTP GET resource that returns a {"message": "ok"} JSON response.
 func (a *App) handlePing(w http.ResponseWriter, req *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write([]byte(`{"message": "ok"}`)); err != nil {
+		log.DefaultLogger.Error("Failed to write ping response", "error", err)
+	}
+}
+
+// handleEcho is an example HTTP POST resource that accepts a JSON with a "message" key and
+// returns to the client whatever it is sent.
+func (a *App) handleEcho(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
 }
 
-// registerRoutes takes a *http.ServeMux and registers some HTTP handlers.
-func (a *App) registerRoutes(mux *http.ServeMux) {
+// allowMethods wraps a handler so that only the given methods reach it. Other
+// methods get a 405 with an Allow header listing the permitted ones.
+func allowMethods(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !slices.Contains(methods, req.Method) {
+			w.Header().Set("Allow", allow)
+			writeJSONError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", req.Method))
+			return
+		}
+		h(w, req)
+	}
+}
+
+// readOnly restricts a handler to GET and HEAD, since the state server views
+// must never be modified through the plugin.
+func readOnly(h http.HandlerFunc) http.HandlerFunc {
+	return allowMethods(h, http.MethodGet, http.MethodHead)
+}
+
+// proxyRoute applies the checks shared by all routes proxying to the state
+// server before the request reaches h.
+func (a *App) proxyRoute(h http.HandlerFunc) http.HandlerFunc {
+	return a.cors(readOnly(a.rateLimited(a.forwardCookies(h))))
+}
+
+// registerRoutes takes a *http.ServeMux and registers some HTTP handlers. It
+// fails if a pattern is registered twice or conflicts with another one.
+//
+// Routes are registered under the routePrefix setting, if any. Routes listed
+// in the disabledEndpoints setting answer 404; the routes left are kept in
+// a.routes.
+func (a *App) registerRoutes(mux *http.ServeMux) error {
+	m := &routeMux{mux: mux}
+	if a.settings != nil {
+		m.prefix = a.settings.RoutePrefix
+		m.disabled = a.settings.DisabledEndpoints
+	}
+
 	// Astrolabe server proxy endpoints
-	mux.HandleFunc("/namespaces", a.handleNamespaces)
-	mux.HandleFunc("/releases", a.handleReleases)
-	mux.HandleFunc("/graph", a.handleGraph)
-	mux.HandleFunc("/resources", a.handleResources)
+	m.HandleFunc("/namespaces", a.proxyRoute(a.handleNamespaces))
+	m.HandleFunc("/releases", a.proxyRoute(a.namespaceScoped(a.handleReleases)))
+	m.HandleFunc("/releases/", a.proxyRoute(a.namespaceScoped(a.handleReleaseResources)))
+	m.HandleFunc("/graph", a.proxyRoute(a.namespaceScoped(a.handleGraph)))
+	m.HandleFunc("/graph/nodes", a.proxyRoute(a.namespaceScoped(a.handleGraphNodes)))
+	m.HandleFunc("/graph/edges", a.proxyRoute(a.namespaceScoped(a.handleGraphEdges)))
+	m.HandleFunc("/graph/stream", a.proxyRoute(a.namespaceScoped(a.handleGraphStream)))
+	m.HandleFunc("/graph/diff", a.proxyRoute(a.namespaceScoped(a.handleGraphDiff)))
+	m.HandleFunc("/resources", a.proxyRoute(a.namespaceScoped(a.handleResources)))
+	m.HandleFunc("/resources/", a.proxyRoute(a.objectNamespaceScoped(a.handleResource)))
+	m.HandleFunc("/workloads", a.proxyRoute(a.namespaceScoped(a.handleWorkloads)))
+	m.HandleFunc("/events", a.proxyRoute(a.namespaceScoped(a.handleEvents)))
+	m.HandleFunc("/kinds", a.proxyRoute(a.namespaceScoped(a.handleKinds)))
+	m.HandleFunc("/search", a.proxyRoute(a.namespaceScoped(a.handleSearch)))
+	m.HandleFunc("/overview", a.proxyRoute(a.namespaceScoped(a.handleOverview)))
+
+	m.HandleFunc("/state-metrics", a.cors(allowMethods(a.rateLimited(a.handleStateServerMetrics), http.MethodGet)))
 
 	// Health check
-	mux.HandleFunc("/ping", a.handlePing)
+	m.HandleFunc("/healthz", readOnly(a.handleHealthz))
+	m.HandleFunc("/live", readOnly(a.handleLive))
+	m.HandleFunc("/ready", readOnly(a.handleReady))
+
+	m.HandleFunc("/version", a.proxyRoute(a.handleVersion))
+	m.HandleFunc("/debug/settings", readOnly(a.handleDebugSettings))
+	m.HandleFunc("/ping", a.handlePing)
+	m.HandleFunc("/echo", a.handleEcho)
+
+	// Any other path gets a JSON 404 like the rest of our errors
+	m.HandleFunc("/", handleNotFound)
+
+	for _, name := range m.disabled {
+		if !m.names[name] {
+			log.DefaultLogger.Warn("Disabled endpoint matches no route", "endpoint", name)
+		}
+	}
+	a.routes = m.routes
+	return m.err
 }
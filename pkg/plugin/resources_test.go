@@ -1,11 +1,22 @@
 package plugin
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // mockCallResourceResponseSender implements backend.CallResourceResponseSender
@@ -99,3 +110,1472 @@ func TestCallResource(t *testing.T) {
 		})
 	}
 }
+
+// newTestApp creates an *App from the given jsonData.
+func newTestApp(t *testing.T, jsonData string) *App {
+	t.Helper()
+	inst, err := NewApp(context.Background(), backend.AppInstanceSettings{JSONData: []byte(jsonData)})
+	if err != nil {
+		t.Fatalf("new app: %s", err)
+	}
+	return inst.(*App)
+}
+
+// TestProxyPropagatesCancellation ensures that cancelling the incoming request
+// aborts the in-flight upstream call.
+func TestProxyPropagatesCancellation(t *testing.T) {
+	received := make(chan struct{})
+	cancelled := make(chan struct{})
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/graph", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		app.proxyToIndexer(httptest.NewRecorder(), req, "graph", "")
+		close(done)
+	}()
+
+	<-received
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream handler did not observe cancellation")
+	}
+	<-done
+}
+
+// TestHandlePing checks the ping response status and headers.
+func TestHandlePing(t *testing.T) {
+	app := newTestApp(t, "")
+
+	rec := httptest.NewRecorder()
+	app.handlePing(rec, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("response status should be %d, got %d", http.StatusOK, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type should be application/json, got %q", ct)
+	}
+}
+
+// TestHandleWorkloads checks that /workloads is proxied with its query intact.
+func TestHandleWorkloads(t *testing.T) {
+	var gotPath, gotQuery string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery = r.URL.Path, r.URL.RawQuery
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/workloads?namespace=default&release=web", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("response status should be %d, got %d", http.StatusOK, rec.Code)
+	}
+	if gotPath != "/api/v1/workloads" {
+		t.Errorf("upstream path should be /api/v1/workloads, got %q", gotPath)
+	}
+	if gotQuery != "namespace=default&release=web" {
+		t.Errorf("upstream query should be preserved, got %q", gotQuery)
+	}
+}
+
+// syntheticGraph returns a JSON document of roughly size bytes.
+func syntheticGraph(size int) []byte {
+	node := []byte(`{"uid":"00000000-0000-0000-0000-000000000000","kind":"Pod","name":"web-0"},`)
+	var buf bytes.Buffer
+	buf.WriteString(`{"nodes":[`)
+	for buf.Len() < size {
+		buf.Write(node)
+	}
+	buf.WriteString(`{}]}`)
+	return buf.Bytes()
+}
+
+// benchmarkProxyGraph proxies a 10MB graph, either sent with a
+// Content-Length (buffered) or chunked and flushed as it is written (streamed).
+func benchmarkProxyGraph(b *testing.B, streamed bool) {
+	graph := syntheticGraph(10 << 20)
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !streamed {
+			w.Header().Set("Content-Length", strconv.Itoa(len(graph)))
+			_, _ = w.Write(graph)
+			return
+		}
+		for chunk := range slices.Chunk(graph, 32<<10) {
+			_, _ = w.Write(chunk)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer indexer.Close()
+
+	inst, err := NewApp(context.Background(), backend.AppInstanceSettings{JSONData: []byte(`{"indexerUrl":"` + indexer.URL + `"}`)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	app := inst.(*App)
+
+	b.SetBytes(int64(len(graph)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/graph", nil)
+		req.Header.Set("Accept-Encoding", "identity")
+		app.proxyToIndexer(httptest.NewRecorder(), req, "graph", "")
+	}
+}
+
+func BenchmarkProxyBuffered(b *testing.B) { benchmarkProxyGraph(b, false) }
+
+func BenchmarkProxyStreamed(b *testing.B) { benchmarkProxyGraph(b, true) }
+
+// TestProxyRewritesPath checks that the route path is appended to the indexer URL.
+func TestProxyRewritesPath(t *testing.T) {
+	var gotPath string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`/astrolabe"}`)
+	app.proxyToIndexer(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+
+	if gotPath != "/astrolabe/api/v1/graph" {
+		t.Errorf("upstream path should be /astrolabe/api/v1/graph, got %q", gotPath)
+	}
+}
+
+// TestProxyEndpointTimeouts checks that per-endpoint timeouts override the
+// global request timeout.
+func TestProxyEndpointTimeouts(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+		}
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","requestTimeoutMs":50,"timeouts":{"graph":5000},"maxRetries":0}`)
+
+	for _, tc := range []struct {
+		endpoint  string
+		expStatus int
+	}{
+		{endpoint: "graph", expStatus: http.StatusOK},
+		{endpoint: "namespaces", expStatus: http.StatusGatewayTimeout},
+	} {
+		t.Run(tc.endpoint, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/"+tc.endpoint, nil), tc.endpoint, "")
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+		})
+	}
+}
+
+// TestProxyIPv6 checks that requests reach a state server addressed by an
+// IPv6 literal with an explicit port.
+func TestProxyIPv6(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %s", err)
+	}
+	var gotPath, gotHost string
+	indexer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotHost = r.URL.Path, r.Host
+	}))
+	indexer.Listener = listener
+	indexer.Start()
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`/astrolabe"}`)
+	rec := httptest.NewRecorder()
+	app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("response status should be 200, got %d", rec.Code)
+	}
+	if gotPath != "/astrolabe/api/v1/graph" {
+		t.Errorf("upstream path should be /astrolabe/api/v1/graph, got %q", gotPath)
+	}
+	if exp := strings.TrimPrefix(indexer.URL, "http://"); gotHost != exp {
+		t.Errorf("upstream Host should be %q, got %q", exp, gotHost)
+	}
+}
+
+// TestProxyErrorHandler checks that unreachable state servers are reported as
+// JSON without the credentials embedded in their URL.
+func TestProxyErrorHandler(t *testing.T) {
+	indexer := httptest.NewServer(http.NotFoundHandler())
+	indexer.Close()
+	hostPort := strings.TrimPrefix(indexer.URL, "http://")
+
+	for _, tc := range []struct {
+		name       string
+		indexerURL string
+	}{
+		{name: "plain", indexerURL: "http://" + hostPort},
+		{name: "userinfo", indexerURL: "http://admin:s3cret@" + hostPort},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t, `{"indexerUrl":"`+tc.indexerURL+`","maxRetries":0}`)
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+
+			if rec.Code != http.StatusBadGateway {
+				t.Errorf("response status should be %d, got %d", http.StatusBadGateway, rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type should be application/json, got %q", ct)
+			}
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("response body should be a JSON error, got %q", rec.Body.String())
+			}
+			if body.Error != "upstream unreachable" || body.Detail == "" {
+				t.Errorf("body should report the upstream as unreachable with detail, got %q", rec.Body.String())
+			}
+			if exp := "http://" + hostPort + "/"; body.Indexer != exp {
+				t.Errorf("indexer should be %q, got %q", exp, body.Indexer)
+			}
+			if strings.Contains(rec.Body.String(), "s3cret") || strings.Contains(rec.Body.String(), "admin") {
+				t.Errorf("body should not contain credentials, got %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestProxyUpstreamFailureCodes checks that a state server that is down is
+// told apart from one that is slow.
+func TestProxyUpstreamFailureCodes(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	for _, tc := range []struct {
+		name       string
+		indexerURL string
+		expStatus  int
+		expCode    string
+	}{
+		{name: "not listening", indexerURL: closed.URL, expStatus: http.StatusBadGateway, expCode: codeUpstreamUnreachable},
+		{name: "slow", indexerURL: slow.URL, expStatus: http.StatusGatewayTimeout, expCode: codeUpstreamTimeout},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t, `{"indexerUrl":"`+tc.indexerURL+`","requestTimeoutMs":50,"maxRetries":0}`)
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("response body should be a JSON error, got %q", rec.Body.String())
+			}
+			if body.Code != tc.expCode {
+				t.Errorf("error code should be %q, got %q", tc.expCode, body.Code)
+			}
+			if body.Indexer == "" {
+				t.Errorf("body should name the state server, got %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestProxyRetries checks that only idempotent requests are retried on 5xx.
+func TestProxyRetries(t *testing.T) {
+	for _, tc := range []struct {
+		method      string
+		expAttempts int
+		expStatus   int
+	}{
+		{method: http.MethodGet, expAttempts: 3, expStatus: http.StatusOK},
+		{method: http.MethodPost, expAttempts: 1, expStatus: http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			attempts := 0
+			indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if attempts <= 2 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer indexer.Close()
+
+			app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, httptest.NewRequest(tc.method, "/graph", bytes.NewReader([]byte(`{}`))), "graph", "")
+
+			if attempts != tc.expAttempts {
+				t.Errorf("upstream should be called %d times, got %d", tc.expAttempts, attempts)
+			}
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+		})
+	}
+}
+
+// TestHandleEvents checks that the since param is only forwarded when set.
+func TestHandleEvents(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		query    string
+		expSince bool
+	}{
+		{name: "since present", query: "namespace=default&involvedObject=web-0&since=2024-01-01T00:00:00Z", expSince: true},
+		{name: "since absent", query: "namespace=default&involvedObject=web-0", expSince: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotPath, gotQuery string
+			var gotSince bool
+			indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath, gotQuery = r.URL.Path, r.URL.RawQuery
+				gotSince = r.URL.Query().Has("since")
+			}))
+			defer indexer.Close()
+
+			app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+			mux := http.NewServeMux()
+			app.registerRoutes(mux)
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/events?"+tc.query, nil))
+
+			if gotPath != "/api/v1/events" {
+				t.Errorf("upstream path should be /api/v1/events, got %q", gotPath)
+			}
+			if gotSince != tc.expSince {
+				t.Errorf("since forwarded should be %t, got query %q", tc.expSince, gotQuery)
+			}
+			if gotQuery != tc.query {
+				t.Errorf("upstream query should be %q, got %q", tc.query, gotQuery)
+			}
+		})
+	}
+}
+
+// TestHandleHealthz checks that the upstream health status is relayed as is.
+func TestHandleHealthz(t *testing.T) {
+	var gotPath string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	rec := httptest.NewRecorder()
+	app.handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if gotPath != "/api/v1/healthz" {
+		t.Errorf("upstream path should be /api/v1/healthz, got %q", gotPath)
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("response status should be %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+// TestProxyClusterSelection checks that the cluster param selects the indexer.
+func TestProxyClusterSelection(t *testing.T) {
+	newIndexer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+	primary, east := newIndexer("primary"), newIndexer("east")
+	defer primary.Close()
+	defer east.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+primary.URL+`","clusters":{"east":"`+east.URL+`"}}`)
+
+	for _, tc := range []struct {
+		name      string
+		query     string
+		expStatus int
+		expBody   string
+	}{
+		{name: "default", query: "", expStatus: http.StatusOK, expBody: "primary"},
+		{name: "known cluster", query: "?cluster=east", expStatus: http.StatusOK, expBody: "east"},
+		{name: "unknown cluster", query: "?cluster=west", expStatus: http.StatusBadRequest, expBody: `{"error":"unknown cluster \"west\""}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/namespaces"+tc.query, nil), "namespaces", "")
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			if body := string(bytes.TrimSpace(rec.Body.Bytes())); body != tc.expBody {
+				t.Errorf("response body should be %s, got %s", tc.expBody, body)
+			}
+		})
+	}
+}
+
+// TestProxyGzip checks that gzip responses are only decompressed for clients
+// that did not ask for gzip.
+func TestProxyGzip(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("upstream Accept-Encoding should be gzip, got %q", r.Header.Get("Accept-Encoding"))
+		}
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write([]byte(`["default"]`))
+		_ = gz.Close()
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		_, _ = w.Write(buf.Bytes())
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+
+	t.Run("client without gzip", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/namespaces", nil), "namespaces", "")
+
+		if ce := rec.Header().Get("Content-Encoding"); ce != "" {
+			t.Errorf("Content-Encoding should be removed, got %q", ce)
+		}
+		if cl := rec.Header().Get("Content-Length"); cl != "" {
+			t.Errorf("Content-Length should be removed, got %q", cl)
+		}
+		if body := rec.Body.String(); body != `["default"]` {
+			t.Errorf("response body should be decompressed, got %q", body)
+		}
+	})
+
+	t.Run("client with gzip", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/namespaces", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		app.proxyToIndexer(rec, req, "namespaces", "")
+
+		if ce := rec.Header().Get("Content-Encoding"); ce != "gzip" {
+			t.Errorf("Content-Encoding should be gzip, got %q", ce)
+		}
+		gz, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("response should be gzip: %s", err)
+		}
+		if body, _ := io.ReadAll(gz); string(body) != `["default"]` {
+			t.Errorf("decompressed body should be [\"default\"], got %q", body)
+		}
+	})
+}
+
+// TestProxyStripsHopByHopHeaders checks that connection-scoped headers are
+// not forwarded upstream.
+func TestProxyStripsHopByHopHeaders(t *testing.T) {
+	var got http.Header
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	req := httptest.NewRequest(http.MethodGet, "/graph", nil)
+	req.Header.Set("Connection", "X-Client-Hop")
+	req.Header.Set("X-Client-Hop", "1")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("X-End-To-End", "1")
+	app.proxyToIndexer(httptest.NewRecorder(), req, "graph", "")
+
+	for _, h := range []string{"X-Client-Hop", "Keep-Alive"} {
+		if v := got.Get(h); v != "" {
+			t.Errorf("%s should not be forwarded, got %q", h, v)
+		}
+	}
+	if v := got.Get("Connection"); strings.Contains(v, "X-Client-Hop") {
+		t.Errorf("client Connection header should not be forwarded, got %q", v)
+	}
+	if v := got.Get("X-End-To-End"); v != "1" {
+		t.Errorf("X-End-To-End should be forwarded, got %q", v)
+	}
+}
+
+// TestProxyRequestID checks that a request ID is forwarded upstream, reusing
+// the client's one when present.
+func TestProxyRequestID(t *testing.T) {
+	var gotID string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.Header.Get("X-Request-ID")
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+
+	req := httptest.NewRequest(http.MethodGet, "/graph", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	app.proxyToIndexer(httptest.NewRecorder(), req, "graph", "")
+	if gotID != "abc-123" {
+		t.Errorf("upstream X-Request-ID should be abc-123, got %q", gotID)
+	}
+
+	app.proxyToIndexer(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+	if gotID == "" || gotID == "abc-123" {
+		t.Errorf("upstream X-Request-ID should be generated, got %q", gotID)
+	}
+}
+
+// TestHandleResource checks the UID is taken from the path and escaped upstream.
+func TestHandleResource(t *testing.T) {
+	var gotPath string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	for _, tc := range []struct {
+		name      string
+		path      string
+		expStatus int
+		expPath   string
+	}{
+		{name: "uid", path: "/resources/0b1c-42", expStatus: http.StatusOK, expPath: "/api/v1/resources/0b1c-42"},
+		{name: "uid with reserved characters", path: "/resources/a%3Fb%2Fc%20d", expStatus: http.StatusOK, expPath: "/api/v1/resources/a%3Fb%2Fc%20d"},
+		{name: "missing uid", path: "/resources/", expStatus: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gotPath = ""
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			if gotPath != tc.expPath {
+				t.Errorf("upstream path should be %q, got %q", tc.expPath, gotPath)
+			}
+		})
+	}
+}
+
+// TestProxyEncodesTarget checks that path and query values with reserved
+// characters reach the state server intact, whether or not the query is
+// re-encoded after filtering.
+func TestProxyEncodesTarget(t *testing.T) {
+	var gotPath, gotToken string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		gotToken = r.URL.Query().Get("continue")
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`/prefix"}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		expPath  string
+		expToken string
+	}{
+		{name: "forwarded query", path: "/graph/nodes?continue=page+a%2Fb", expPath: "/prefix/api/v1/graph/nodes", expToken: "page a/b"},
+		{name: "filtered query", path: "/graph/nodes?continue=page+a%2Fb&debug=1", expPath: "/prefix/api/v1/graph/nodes", expToken: "page a/b"},
+		{name: "escaped path", path: "/resources/a%2Fb%20c", expPath: "/prefix/api/v1/resources/a%2Fb%20c"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("response status should be %d, got %d", http.StatusOK, rec.Code)
+			}
+			if gotPath != tc.expPath {
+				t.Errorf("upstream path should be %q, got %q", tc.expPath, gotPath)
+			}
+			if gotToken != tc.expToken {
+				t.Errorf("upstream continue token should be %q, got %q", tc.expToken, gotToken)
+			}
+		})
+	}
+}
+
+func TestHandleReleaseResources(t *testing.T) {
+	var gotPath string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	for _, tc := range []struct {
+		name      string
+		path      string
+		expStatus int
+		expPath   string
+	}{
+		{name: "release", path: "/releases/web/resources", expStatus: http.StatusOK, expPath: "/api/v1/releases/web/resources"},
+		{name: "escaped name", path: "/releases/web%3Fv2/resources", expStatus: http.StatusOK, expPath: "/api/v1/releases/web%3Fv2/resources"},
+		{name: "missing resources", path: "/releases/web", expStatus: http.StatusNotFound},
+		{name: "nested name", path: "/releases/a/b/resources", expStatus: http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gotPath = ""
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			if gotPath != tc.expPath {
+				t.Errorf("upstream path should be %q, got %q", tc.expPath, gotPath)
+			}
+		})
+	}
+
+	t.Run("missing name", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		app.handleReleaseResources(rec, httptest.NewRequest(http.MethodGet, "/releases//resources", nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("response status should be %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+}
+
+// TestProxyRequestBodyLimit checks that oversized bodies are rejected with
+// 413 before reaching the state server, whether or not their length is known.
+func TestProxyRequestBodyLimit(t *testing.T) {
+	called := false
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxRequestBodyBytes":16}`)
+
+	for _, tc := range []struct {
+		name          string
+		method        string
+		contentLength int64
+	}{
+		{name: "post", method: http.MethodPost, contentLength: 17},
+		{name: "get with unknown length", method: http.MethodGet, contentLength: -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			called = false
+			req := httptest.NewRequest(tc.method, "/graph", strings.NewReader(strings.Repeat("x", 17)))
+			req.ContentLength = tc.contentLength
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, req, "graph", "")
+
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("response status should be %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+			}
+			if called {
+				t.Error("oversized request should not reach the state server")
+			}
+		})
+	}
+}
+
+func TestProxyResponseLimit(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `[{"kind":"Pod","name":"api-0"},{"kind":"Pod","name":"api-1"}]`
+		if r.URL.Path == "/api/v1/namespaces" {
+			body = `["default"]`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("kind") != "" {
+			// Flushing before the body is written leaves the length unknown
+			w.(http.Flusher).Flush()
+		}
+		_, _ = io.WriteString(w, body)
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxResponseBytes":32}`)
+
+	for _, tc := range []struct {
+		name     string
+		endpoint string
+		target   string
+		status   int
+	}{
+		{name: "within limit", endpoint: "namespaces", target: "/namespaces", status: http.StatusOK},
+		{name: "content length", endpoint: "resources", target: "/resources", status: http.StatusBadGateway},
+		{name: "chunked and filtered", endpoint: "resources", target: "/resources?kind=Pod&serverSideFilter=true", status: http.StatusBadGateway},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, tc.target, nil), tc.endpoint, "")
+
+			if rec.Code != tc.status {
+				t.Errorf("response status should be %d, got %d", tc.status, rec.Code)
+			}
+			if tc.status == http.StatusBadGateway && !strings.Contains(rec.Body.String(), "exceeds 32 bytes") {
+				t.Errorf("response should report the limit, got %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestProxyUpstreamDurationHeader(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	rec := httptest.NewRecorder()
+	app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+
+	ms, err := strconv.Atoi(rec.Header().Get(upstreamDurationHeader))
+	if err != nil {
+		t.Fatalf("%s should be numeric: %s", upstreamDurationHeader, err)
+	}
+	if ms < 5 {
+		t.Errorf("%s should be at least 5, got %d", upstreamDurationHeader, ms)
+	}
+}
+
+// TestHandleResourcesKindFilter checks that kind is forwarded upstream and
+// that serverSideFilter=true filters the list in the plugin.
+func TestHandleResourcesKindFilter(t *testing.T) {
+	var gotQuery url.Values
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("namespace") == "wrapped" {
+			_, _ = w.Write([]byte(`{"items":[{"kind":"Pod","name":"web-0"},{"kind":"Service","name":"web"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"kind":"Pod","name":"web-0"},{"kind":"Service","name":"web"}]`))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		expBody string
+	}{
+		{name: "forwarded", path: "/resources?kind=Pod", expBody: `[{"kind":"Pod","name":"web-0"},{"kind":"Service","name":"web"}]`},
+		{name: "filtered", path: "/resources?kind=Pod&serverSideFilter=true", expBody: `[{"kind":"Pod","name":"web-0"}]`},
+		{name: "filtered items", path: "/resources?namespace=wrapped&kind=Service&serverSideFilter=true", expBody: `{"items":[{"kind":"Service","name":"web"}]}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("response status should be %d, got %d", http.StatusOK, rec.Code)
+			}
+			if rec.Body.String() != tc.expBody {
+				t.Errorf("body should be %s, got %s", tc.expBody, rec.Body.String())
+			}
+			if gotQuery.Get("kind") == "" {
+				t.Error("kind should be forwarded upstream")
+			}
+			if gotQuery.Has("serverSideFilter") {
+				t.Error("serverSideFilter should not be forwarded upstream")
+			}
+		})
+	}
+}
+
+func TestHandleGraphReleaseFilter(t *testing.T) {
+	var gotQuery url.Values
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"nodes":[{"uid":"a","release":"web"},{"uid":"b","release":"web"},{"uid":"c","release":"db"}],` +
+			`"edges":[{"from":"a","to":"b","type":"owns"},{"from":"b","to":"c","type":"selects"}]}`))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		expBody string
+	}{
+		{
+			name: "forwarded",
+			path: "/graph?release=web",
+			expBody: `{"nodes":[{"uid":"a","release":"web"},{"uid":"b","release":"web"},{"uid":"c","release":"db"}],` +
+				`"edges":[{"from":"a","to":"b","type":"owns"},{"from":"b","to":"c","type":"selects"}]}`,
+		},
+		{
+			name:    "filtered",
+			path:    "/graph?release=web&serverSideFilter=true",
+			expBody: `{"edges":[{"from":"a","to":"b","type":"owns"}],"nodes":[{"uid":"a","release":"web"},{"uid":"b","release":"web"}]}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("response status should be %d, got %d", http.StatusOK, rec.Code)
+			}
+			if rec.Body.String() != tc.expBody {
+				t.Errorf("body should be %s, got %s", tc.expBody, rec.Body.String())
+			}
+			if gotQuery.Get("release") != "web" {
+				t.Errorf("release should be forwarded upstream, got %q", gotQuery.Get("release"))
+			}
+			if gotQuery.Has("serverSideFilter") {
+				t.Error("serverSideFilter should not be forwarded upstream")
+			}
+		})
+	}
+}
+
+// TestReadOnlyRoutes checks that proxy routes reject non-read methods before
+// anything is sent upstream.
+func TestReadOnlyRoutes(t *testing.T) {
+	called := false
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graph", bytes.NewReader([]byte(`{}`))))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("response status should be %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Errorf("Allow header should be %q, got %q", "GET, HEAD", allow)
+	}
+	if called {
+		t.Error("request should not reach the state server")
+	}
+}
+
+// TestProxyAuthorization checks the credentials sent upstream for each
+// combination of configured secrets.
+func TestProxyAuthorization(t *testing.T) {
+	var gotAuth string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer indexer.Close()
+
+	for _, tc := range []struct {
+		name    string
+		secure  map[string]string
+		expAuth string
+	}{
+		{name: "none"},
+		{name: "token", secure: map[string]string{"indexerToken": "s3cret"}, expAuth: "Bearer s3cret"},
+		{name: "basic auth", secure: map[string]string{"indexerUsername": "grafana", "indexerPassword": "pa55"}, expAuth: "Basic Z3JhZmFuYTpwYTU1"},
+		{
+			name:    "token takes precedence",
+			secure:  map[string]string{"indexerToken": "s3cret", "indexerUsername": "grafana", "indexerPassword": "pa55"},
+			expAuth: "Bearer s3cret",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inst, err := NewApp(context.Background(), backend.AppInstanceSettings{
+				JSONData:                []byte(`{"indexerUrl":"` + indexer.URL + `"}`),
+				DecryptedSecureJSONData: tc.secure,
+			})
+			if err != nil {
+				t.Fatalf("new app: %s", err)
+			}
+			gotAuth = ""
+			rec := httptest.NewRecorder()
+			inst.(*App).proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+
+			if gotAuth != tc.expAuth {
+				t.Errorf("upstream Authorization should be %q, got %q", tc.expAuth, gotAuth)
+			}
+		})
+	}
+}
+
+// TestProxyForwardsIdentity checks that the Grafana user and org are taken
+// from the plugin context rather than from client headers.
+func TestProxyForwardsIdentity(t *testing.T) {
+	var got http.Header
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+
+	for _, tc := range []struct {
+		name      string
+		pluginCtx backend.PluginContext
+		expUser   string
+		expOrgID  string
+	}{
+		{
+			name:      "signed in user",
+			pluginCtx: backend.PluginContext{OrgID: 2, User: &backend.User{Login: "alice"}},
+			expUser:   "alice",
+			expOrgID:  "2",
+		},
+		{
+			name: "no plugin context",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var r mockCallResourceResponseSender
+			err := app.CallResource(context.Background(), &backend.CallResourceRequest{
+				PluginContext: tc.pluginCtx,
+				Method:        http.MethodGet,
+				Path:          "namespaces",
+				Headers: map[string][]string{
+					"X-Grafana-User":   {"mallory"},
+					"X-Grafana-Org-Id": {"1"},
+				},
+			}, &r)
+			if err != nil {
+				t.Fatalf("CallResource error: %s", err)
+			}
+			if v := got.Get("X-Grafana-User"); v != tc.expUser {
+				t.Errorf("X-Grafana-User should be %q, got %q", tc.expUser, v)
+			}
+			if v := got.Get("X-Grafana-Org-Id"); v != tc.expOrgID {
+				t.Errorf("X-Grafana-Org-Id should be %q, got %q", tc.expOrgID, v)
+			}
+		})
+	}
+}
+
+// TestHandleResourcesPagination checks that pagination params and the
+// continue token round-trip through the proxy.
+func TestHandleResourcesPagination(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("limit") != "50" {
+			t.Errorf("upstream limit should be 50, got %q", r.URL.Query().Get("limit"))
+		}
+		w.Header().Set("X-Continue-Token", "next-"+r.URL.Query().Get("continue"))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resources?limit=50&continue=abc", nil))
+
+	if token := rec.Header().Get("X-Continue-Token"); token != "next-abc" {
+		t.Errorf("X-Continue-Token should be next-abc, got %q", token)
+	}
+}
+
+func TestHandleGraphPages(t *testing.T) {
+	var gotPath string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		if r.URL.Query().Get("limit") != "100" {
+			t.Errorf("upstream limit should be 100, got %q", r.URL.Query().Get("limit"))
+		}
+		w.Header().Set("X-Continue-Token", "next-"+r.URL.Query().Get("continue"))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	for _, tc := range []struct {
+		path    string
+		expPath string
+	}{
+		{path: "/graph/nodes", expPath: "/api/v1/graph/nodes"},
+		{path: "/graph/edges", expPath: "/api/v1/graph/edges"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path+"?namespace=default&limit=100&continue=abc", nil))
+
+			if gotPath != tc.expPath {
+				t.Errorf("upstream path should be %q, got %q", tc.expPath, gotPath)
+			}
+			if token := rec.Header().Get("X-Continue-Token"); token != "next-abc" {
+				t.Errorf("X-Continue-Token should be next-abc, got %q", token)
+			}
+		})
+	}
+}
+
+func TestHandleStateServerMetrics(t *testing.T) {
+	const metrics = "# HELP astrolabe_resources Indexed resources.\n# TYPE astrolabe_resources gauge\nastrolabe_resources 42\n"
+	var gotPath, gotAccept string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAccept = r.Header.Get("Accept")
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(metrics))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	req := httptest.NewRequest(http.MethodGet, "/state-metrics", nil)
+	req.Header.Set("Accept", "text/plain")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("response status should be %d, got %d", http.StatusOK, rec.Code)
+	}
+	if gotPath != "/metrics" {
+		t.Errorf("upstream path should be /metrics, got %q", gotPath)
+	}
+	if gotAccept != "text/plain" {
+		t.Errorf("upstream Accept should be text/plain, got %q", gotAccept)
+	}
+	if rec.Body.String() != metrics {
+		t.Errorf("body should be passed through, got %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/state-metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("HEAD status should be %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+// TestHandleGraphStream checks that Server-Sent Events are relayed as they
+// arrive and that the stream outlives the request timeout.
+func TestHandleGraphStream(t *testing.T) {
+	next := make(chan struct{})
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		<-next
+		_, _ = w.Write([]byte("data: 2\n\n"))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","requestTimeoutMs":50}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+	front := httptest.NewServer(mux)
+	defer front.Close()
+
+	resp, err := http.Get(front.URL + "/graph/stream")
+	if err != nil {
+		t.Fatalf("stream request error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	events := bufio.NewScanner(resp.Body)
+	readEvent := func() string {
+		t.Helper()
+		for events.Scan() {
+			if line := events.Text(); line != "" {
+				return line
+			}
+		}
+		t.Fatalf("stream ended early: %v", events.Err())
+		return ""
+	}
+
+	// The first event must arrive while the upstream holds the stream open
+	if event := readEvent(); event != "data: 1" {
+		t.Errorf("first event should be %q, got %q", "data: 1", event)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(next)
+	if event := readEvent(); event != "data: 2" {
+		t.Errorf("second event should be %q, got %q", "data: 2", event)
+	}
+}
+
+// TestProxyWrapsNonJSONErrors checks that HTML error pages are turned into
+// JSON errors with the upstream status preserved.
+func TestProxyWrapsNonJSONErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		expBody     errorResponse
+	}{
+		{
+			name:        "html error page",
+			contentType: "text/html",
+			body:        "<html><body>503 Service Temporarily Unavailable</body></html>",
+			expBody: errorResponse{
+				Error:  "astrolabe server returned 503 Service Unavailable",
+				Status: http.StatusServiceUnavailable,
+				Detail: "<html><body>503 Service Temporarily Unavailable</body></html>",
+			},
+		},
+		{
+			name:        "json error",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"error":"namespace not indexed"}`,
+			expBody:     errorResponse{Error: "namespace not indexed"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer indexer.Close()
+
+			app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxRetries":0}`)
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("response status should be %d, got %d", http.StatusServiceUnavailable, rec.Code)
+			}
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("response body should be JSON, got %q", rec.Body.String())
+			}
+			if body != tc.expBody {
+				t.Errorf("response body should be %+v, got %+v", tc.expBody, body)
+			}
+		})
+	}
+}
+
+// TestProxyQueryAllowlist checks that only the params allowed for an endpoint
+// reach the state server.
+func TestProxyQueryAllowlist(t *testing.T) {
+	var gotQuery string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		expQuery string
+	}{
+		{name: "injected param on namespaces", path: "/namespaces?admin=true", expQuery: ""},
+		{name: "allowed params untouched", path: "/resources?namespace=default&limit=10", expQuery: "namespace=default&limit=10"},
+		{name: "mixed params", path: "/graph?release=web&admin=true&namespace=default", expQuery: "namespace=default&release=web"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if gotQuery != tc.expQuery {
+				t.Errorf("upstream query should be %q, got %q", tc.expQuery, gotQuery)
+			}
+		})
+	}
+}
+
+// TestProxyNamespaceMultiSelect checks that repeated namespace params, as sent
+// by multi-value dashboard variables, reach the state server intact.
+func TestProxyNamespaceMultiSelect(t *testing.T) {
+	var gotNamespaces []string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotNamespaces = r.URL.Query()["namespace"]
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","orgNamespaceAllowlist":{"2":["a","b"]}}`)
+	mux := http.NewServeMux()
+	if err := app.registerRoutes(mux); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		orgID int64
+		path  string
+	}{
+		{name: "graph", orgID: 1, path: "/graph?namespace=a&namespace=b"},
+		{name: "resources", orgID: 1, path: "/resources?namespace=a&namespace=b"},
+		{name: "dropped params", orgID: 1, path: "/graph?namespace=a&admin=true&namespace=b"},
+		{name: "restricted org", orgID: 2, path: "/resources?namespace=a&namespace=b"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gotNamespaces = nil
+			ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: tc.orgID})
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil).WithContext(ctx))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("response status should be 200, got %d", rec.Code)
+			}
+			if !slices.Equal(gotNamespaces, []string{"a", "b"}) {
+				t.Errorf("upstream namespaces should be [a b], got %v", gotNamespaces)
+			}
+		})
+	}
+}
+
+// TestProxyDefaultNamespace checks that the default namespace is added to
+// requests not selecting one and that a requested namespace wins.
+func TestProxyDefaultNamespace(t *testing.T) {
+	var gotQuery string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","defaultNamespace":"team-a","orgNamespaceAllowlist":{"2":["team-a"]}}`)
+	mux := http.NewServeMux()
+	if err := app.registerRoutes(mux); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		orgID     int64
+		path      string
+		expStatus int
+		expQuery  string
+	}{
+		{name: "resources injected", orgID: 1, path: "/resources", expStatus: http.StatusOK, expQuery: "namespace=team-a"},
+		{name: "graph injected", orgID: 1, path: "/graph?release=web", expStatus: http.StatusOK, expQuery: "release=web&namespace=team-a"},
+		{name: "requested namespace wins", orgID: 1, path: "/graph?namespace=team-b", expStatus: http.StatusOK, expQuery: "namespace=team-b"},
+		{name: "no namespace param", orgID: 1, path: "/namespaces", expStatus: http.StatusOK, expQuery: ""},
+		{name: "restricted org injected", orgID: 2, path: "/resources", expStatus: http.StatusOK, expQuery: "namespace=team-a"},
+		{name: "restricted org override", orgID: 2, path: "/resources?namespace=team-b", expStatus: http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gotQuery = ""
+			ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{OrgID: tc.orgID})
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil).WithContext(ctx))
+
+			if rec.Code != tc.expStatus {
+				t.Fatalf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			if gotQuery != tc.expQuery {
+				t.Errorf("upstream query should be %q, got %q", tc.expQuery, gotQuery)
+			}
+		})
+	}
+}
+
+// TestHandleKinds checks that the kinds are proxied with their namespace and
+// that state servers without the endpoint yield an empty list.
+func TestHandleKinds(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		status    int
+		expStatus int
+		expBody   string
+	}{
+		{name: "supported", status: http.StatusOK, expStatus: http.StatusOK, expBody: `[{"kind":"Pod"}]`},
+		{name: "not found", status: http.StatusNotFound, expStatus: http.StatusOK, expBody: `[]`},
+		{name: "server error", status: http.StatusInternalServerError, expStatus: http.StatusInternalServerError, expBody: `{"error":"failed"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotPath, gotNamespace string
+			indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath, gotNamespace = r.URL.Path, r.URL.Query().Get("namespace")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.status)
+				switch tc.status {
+				case http.StatusOK:
+					_, _ = w.Write([]byte(`[{"kind":"Pod"}]`))
+				case http.StatusNotFound:
+					_, _ = w.Write([]byte(`{"error":"not found"}`))
+				default:
+					_, _ = w.Write([]byte(`{"error":"failed"}`))
+				}
+			}))
+			defer indexer.Close()
+
+			app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxRetries":0}`)
+			mux := http.NewServeMux()
+			if err := app.registerRoutes(mux); err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/kinds?namespace=default", nil))
+
+			if gotPath != "/api/v1/kinds" || gotNamespace != "default" {
+				t.Errorf("upstream should get /api/v1/kinds?namespace=default, got %s namespace=%q", gotPath, gotNamespace)
+			}
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			if body := strings.TrimSpace(rec.Body.String()); body != tc.expBody {
+				t.Errorf("response body should be %s, got %s", tc.expBody, body)
+			}
+		})
+	}
+}
+
+// TestProxyHead checks that HEAD relays the upstream status and headers only.
+func TestProxyHead(t *testing.T) {
+	var gotMethod string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("X-Total-Count", "3")
+		w.WriteHeader(http.StatusPartialContent)
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	mux := http.NewServeMux()
+	app.registerRoutes(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/releases", nil))
+
+	if gotMethod != http.MethodHead {
+		t.Errorf("upstream method should be HEAD, got %s", gotMethod)
+	}
+	if rec.Code != http.StatusPartialContent {
+		t.Errorf("response status should be %d, got %d", http.StatusPartialContent, rec.Code)
+	}
+	if v := rec.Header().Get("X-Total-Count"); v != "3" {
+		t.Errorf("X-Total-Count should be relayed, got %q", v)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("response body should be empty, got %q", rec.Body.String())
+	}
+}
+
+// TestProxyPrettyJSON checks that pretty=true re-indents JSON responses, even
+// for clients accepting gzip, and leaves errors as they are.
+func TestProxyPrettyJSON(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("pretty") {
+			t.Error("pretty should not be forwarded upstream")
+		}
+		if r.URL.Path == "/api/v1/kinds" {
+			writeJSONError(w, http.StatusBadRequest, "bad")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"nodes":[{"uid":"a"}],"edges":[]}`))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	for _, tc := range []struct {
+		name     string
+		path     string
+		endpoint string
+		expBody  string
+	}{
+		{name: "pretty", path: "/graph?pretty=true", endpoint: "graph", expBody: "{\n  \"nodes\": [\n    {\n      \"uid\": \"a\"\n    }\n  ],\n  \"edges\": []\n}\n"},
+		{name: "compact", path: "/graph", endpoint: "graph", expBody: `{"nodes":[{"uid":"a"}],"edges":[]}`},
+		{name: "error", path: "/kinds?pretty=true", endpoint: "kinds", expBody: "{\"error\":\"bad\"}\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.name != "compact" {
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, req, tc.endpoint, "")
+
+			if encoding := rec.Header().Get("Content-Encoding"); encoding != "" {
+				t.Errorf("response should not be compressed, got %q", encoding)
+			}
+			if rec.Body.String() != tc.expBody {
+				t.Errorf("body should be %q, got %q", tc.expBody, rec.Body)
+			}
+			if length := rec.Header().Get("Content-Length"); length != "" && length != strconv.Itoa(rec.Body.Len()) {
+				t.Errorf("content length should be %d, got %s", rec.Body.Len(), length)
+			}
+		})
+	}
+}
+
+// TestProxyUnwrapJSON checks that unwrap extracts a field of enveloped JSON
+// responses, defaulting to the unwrapField setting, and relays the others
+// unchanged.
+func TestProxyUnwrapJSON(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("unwrap") {
+			t.Error("unwrap should not be forwarded upstream")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/api/v1/kinds" {
+			_, _ = w.Write([]byte(`["Pod"]`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"nodes":[],"edges":[]},"meta":{"count":0}}`))
+	}))
+	defer indexer.Close()
+
+	for _, tc := range []struct {
+		name     string
+		field    string
+		path     string
+		endpoint string
+		expBody  string
+	}{
+		{name: "param", path: "/graph?unwrap=data", endpoint: "graph", expBody: `{"nodes":[],"edges":[]}`},
+		{name: "param with pretty", path: "/graph?unwrap=meta&pretty=true", endpoint: "graph", expBody: "{\n  \"count\": 0\n}\n"},
+		{name: "setting", field: "data", path: "/graph", endpoint: "graph", expBody: `{"nodes":[],"edges":[]}`},
+		{name: "param overrides setting", field: "data", path: "/graph?unwrap=", endpoint: "graph", expBody: `{"data":{"nodes":[],"edges":[]},"meta":{"count":0}}`},
+		{name: "absent field", path: "/graph?unwrap=items", endpoint: "graph", expBody: `{"data":{"nodes":[],"edges":[]},"meta":{"count":0}}`},
+		{name: "not an object", field: "data", path: "/kinds", endpoint: "kinds", expBody: `["Pod"]`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","unwrapField":"`+tc.field+`"}`)
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, tc.path, nil), tc.endpoint, "")
+
+			if rec.Body.String() != tc.expBody {
+				t.Errorf("body should be %q, got %q", tc.expBody, rec.Body)
+			}
+			if length := rec.Header().Get("Content-Length"); length != "" && length != strconv.Itoa(rec.Body.Len()) {
+				t.Errorf("content length should be %d, got %s", rec.Body.Len(), length)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package plugin
+
+import (
+	"net/http"
+	"slices"
+)
+
+// defaultResponseHeaders are the upstream response headers relayed to the
+// client. The others, such as Server or debug headers of the state server,
+// are dropped so internal details don't reach the browser.
+var defaultResponseHeaders = []string{
+	"Cache-Control",
+	"Content-Encoding",
+	"Content-Length",
+	"Content-Type",
+	"Etag",
+	"Last-Modified",
+	"Location",
+	"Retry-After",
+	"Vary",
+	"X-Continue-Token",
+	"X-Total-Count",
+	requestIDHeader,
+}
+
+// responseHeaderAllowlist returns the canonical names of the response headers
+// relayed: the defaults plus the configured extra ones.
+func responseHeaderAllowlist(extra []string) []string {
+	var allowed []string
+	for _, name := range slices.Concat(defaultResponseHeaders, extra) {
+		allowed = append(allowed, http.CanonicalHeaderKey(name))
+	}
+	return allowed
+}
+
+// filterResponseHeaders removes the headers of an upstream response that are
+// not allowed.
+func filterResponseHeaders(h http.Header, allowed []string) {
+	for name := range h {
+		if !slices.Contains(allowed, name) {
+			h.Del(name)
+		}
+	}
+}
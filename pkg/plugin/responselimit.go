@@ -0,0 +1,54 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// responseTooLargeError is returned when reading an upstream response body
+// past the configured maximum.
+type responseTooLargeError struct {
+	limit int64
+}
+
+func (e *responseTooLargeError) Error() string {
+	return fmt.Sprintf("response from astrolabe server exceeds %d bytes", e.limit)
+}
+
+// limitResponse caps the body of resp at limit bytes. A response announcing
+// a larger body is rejected right away; otherwise reading past the limit
+// fails with a responseTooLargeError, which fails the buffering transforms
+// and keeps the truncated body out of the caches.
+func limitResponse(resp *http.Response, limit int64) error {
+	if resp.ContentLength > limit {
+		resp.Body.Close()
+		return &responseTooLargeError{limit: limit}
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limit, limit: limit}
+	return nil
+}
+
+// limitedBody reads at most limit bytes of a body.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, &responseTooLargeError{limit: b.limit}
+	}
+	// Read one byte more than allowed to tell a body of exactly limit bytes
+	// from a longer one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), &responseTooLargeError{limit: b.limit}
+	}
+	return n, err
+}
@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// retryBaseDelay is the backoff before the first retry; it doubles on every
+// subsequent attempt.
+const retryBaseDelay = 100 * time.Millisecond
+
+// isIdempotent reports whether requests with the given method may be retried.
+func isIdempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// shouldRetry reports whether an upstream attempt failed in a way that is
+// likely transient, such as the state server restarting or asking us to slow
+// down.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return errors.Is(err, syscall.ECONNREFUSED)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return resp.Header.Get("Retry-After") != ""
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// retryAfter returns the delay asked for by the Retry-After header of a 429 or
+// 503 response, given either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// retryTransport retries idempotent requests on transient failures with
+// exponential backoff, or after the delay asked for by Retry-After, capped at
+// maxRetryAfter. Request bodies, if any, must be replayable through
+// req.GetBody.
+type retryTransport struct {
+	next          http.RoundTripper
+	maxRetries    int
+	maxRetryAfter time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if isIdempotent(req.Method) {
+		attempts += t.maxRetries
+	}
+
+	attemptReq := req
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(attemptReq)
+		if attempt == attempts-1 || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		delay := retryBaseDelay << attempt
+		if wait, ok := retryAfter(resp, time.Now()); ok {
+			delay = min(wait, t.maxRetryAfter)
+			// Relay the response rather than wait past the request timeout
+			if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < delay {
+				return resp, err
+			}
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		log.DefaultLogger.Debug("Retrying upstream request", "attempt", attempt+1, "delay", delay, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		attemptReq = req.Clone(req.Context())
+		if req.Body != nil && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+	}
+}
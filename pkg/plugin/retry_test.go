@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRetryAfter checks that both forms of Retry-After are parsed.
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name     string
+		status   int
+		header   string
+		expDelay time.Duration
+		expOK    bool
+	}{
+		{name: "seconds", status: http.StatusTooManyRequests, header: "3", expDelay: 3 * time.Second, expOK: true},
+		{name: "date", status: http.StatusServiceUnavailable, header: now.Add(5 * time.Second).Format(http.TimeFormat), expDelay: 5 * time.Second, expOK: true},
+		{name: "past date", status: http.StatusServiceUnavailable, header: now.Add(-time.Minute).Format(http.TimeFormat), expOK: true},
+		{name: "missing", status: http.StatusServiceUnavailable},
+		{name: "invalid", status: http.StatusTooManyRequests, header: "soon"},
+		{name: "other status", status: http.StatusInternalServerError, header: "3"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tc.status, Header: http.Header{}}
+			if tc.header != "" {
+				resp.Header.Set("Retry-After", tc.header)
+			}
+			delay, ok := retryAfter(resp, now)
+			if ok != tc.expOK || delay != tc.expDelay {
+				t.Errorf("retryAfter should be %s %t, got %s %t", tc.expDelay, tc.expOK, delay, ok)
+			}
+		})
+	}
+}
+
+// TestRetryTransportRetryAfter checks that Retry-After is waited for, capped,
+// and that the response is relayed when the wait would outlast the request.
+func TestRetryTransportRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		status      int
+		retryAfter  string
+		timeout     time.Duration
+		expAttempts int
+		expStatus   int
+	}{
+		{name: "429 retried", status: http.StatusTooManyRequests, retryAfter: "120", expAttempts: 2, expStatus: http.StatusOK},
+		{name: "503 retried", status: http.StatusServiceUnavailable, retryAfter: "120", expAttempts: 2, expStatus: http.StatusOK},
+		{name: "429 without Retry-After", status: http.StatusTooManyRequests, expAttempts: 1, expStatus: http.StatusTooManyRequests},
+		{name: "wait exceeds timeout", status: http.StatusTooManyRequests, retryAfter: "120", timeout: 20 * time.Millisecond, expAttempts: 1, expStatus: http.StatusTooManyRequests},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if attempts == 1 {
+					if tc.retryAfter != "" {
+						w.Header().Set("Retry-After", tc.retryAfter)
+					}
+					w.WriteHeader(tc.status)
+				}
+			}))
+			defer indexer.Close()
+
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, indexer.URL, nil)
+			transport := &retryTransport{next: http.DefaultTransport, maxRetries: 2, maxRetryAfter: 50 * time.Millisecond}
+
+			start := time.Now()
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("RoundTrip error: %s", err)
+			}
+			resp.Body.Close()
+
+			if attempts != tc.expAttempts {
+				t.Errorf("upstream should be called %d times, got %d", tc.expAttempts, attempts)
+			}
+			if resp.StatusCode != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, resp.StatusCode)
+			}
+			if elapsed := time.Since(start); tc.expAttempts > 1 && elapsed < 50*time.Millisecond {
+				t.Errorf("retry should wait for the capped Retry-After, waited %s", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// maxRewriteBodyBytes caps the responses rewritten by a rewriteWriter. Larger
+// ones are relayed as they are.
+const maxRewriteBodyBytes = 10 << 20
+
+// rewriteWriter buffers a response to rewrite it if it is a successful,
+// uncompressed JSON body once finish is called, as asked for by the pretty
+// and unwrap params. Past maxRewriteBodyBytes it gives up and writes the rest
+// through. It deliberately can't be flushed or unwrapped, so nothing reaches
+// the client before finish.
+type rewriteWriter struct {
+	http.ResponseWriter
+	rewrites []func([]byte) ([]byte, error)
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func newRewriteWriter(w http.ResponseWriter, rewrites ...func([]byte) ([]byte, error)) *rewriteWriter {
+	return &rewriteWriter{ResponseWriter: w, rewrites: rewrites, status: http.StatusOK}
+}
+
+func (w *rewriteWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *rewriteWriter) Write(p []byte) (int, error) {
+	if w.overflow {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.body.Len()+len(p) <= maxRewriteBodyBytes {
+		return w.body.Write(p)
+	}
+	w.overflow = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+		return 0, err
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// finish writes out the buffered response, rewritten if possible. A rewrite
+// that fails leaves the body as it was.
+func (w *rewriteWriter) finish() {
+	if w.overflow {
+		return
+	}
+	body := w.body.Bytes()
+	header := w.Header()
+	if w.status == http.StatusOK && isJSONContentType(header.Get("Content-Type")) && header.Get("Content-Encoding") == "" {
+		for _, rewrite := range w.rewrites {
+			if rewritten, err := rewrite(body); err == nil {
+				body = rewritten
+			}
+		}
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(body)
+}
+
+// indentJSON re-indents a JSON body for pretty=true.
+func indentJSON(body []byte) ([]byte, error) {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, body, "", "  "); err != nil {
+		return nil, err
+	}
+	indented.WriteByte('\n')
+	return indented.Bytes(), nil
+}
+
+// unwrapJSON returns a rewrite extracting field from a JSON object, e.g. the
+// payload of a {"data": ..., "meta": ...} envelope. Bodies that aren't objects
+// or lack the field are left as they are.
+func unwrapJSON(field string) func([]byte) ([]byte, error) {
+	return func(body []byte) ([]byte, error) {
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return body, nil
+		}
+		if payload, ok := envelope[field]; ok {
+			return payload, nil
+		}
+		return body, nil
+	}
+}
@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// routeMux registers handlers on a ServeMux, turning the panic ServeMux
+// raises for duplicate or conflicting patterns into an error. Registration
+// stops at the first failure, which is kept in err.
+//
+// Patterns are registered under prefix, which is stripped again before the
+// handlers see the request. The catch-all "/" is the exception: it is left
+// unprefixed so that it answers every path no other route matches.
+//
+// Routes named in disabled answer 404 instead, see routeName. The patterns of
+// the routes that are enabled are collected in routes and the names of all
+// routes in names.
+type routeMux struct {
+	mux      *http.ServeMux
+	prefix   string
+	disabled []string
+	routes   []string
+	names    map[string]bool
+	err      error
+}
+
+// routeName is the name a route is disabled by: its pattern without the
+// leading and trailing slashes. Subtree patterns share the name of the route
+// they extend, so that "resources" disables both /resources and
+// /resources/{uid}.
+func routeName(pattern string) string {
+	return strings.Trim(pattern, "/")
+}
+
+// HandleFunc registers handler for pattern unless an earlier route failed.
+func (m *routeMux) HandleFunc(pattern string, handler http.HandlerFunc) {
+	if m.err != nil {
+		return
+	}
+	if m.names == nil {
+		m.names = map[string]bool{}
+	}
+	name := routeName(pattern)
+	m.names[name] = true
+	if m.prefix != "" && pattern != "/" {
+		handler = http.StripPrefix(m.prefix, handler).ServeHTTP
+		pattern = m.prefix + pattern
+	}
+	if slices.Contains(m.disabled, name) {
+		handler = func(w http.ResponseWriter, _ *http.Request) {
+			writeJSONError(w, http.StatusNotFound, "not found")
+		}
+	} else {
+		m.routes = append(m.routes, pattern)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			m.err = fmt.Errorf("failed to register route %q: %v", pattern, r)
+		}
+	}()
+	m.mux.HandleFunc(pattern, handler)
+}
@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRouteMux(t *testing.T) {
+	noop := func(http.ResponseWriter, *http.Request) {}
+
+	for _, tc := range []struct {
+		name     string
+		patterns []string
+		expErr   string
+	}{
+		{name: "distinct", patterns: []string{"/graph", "/graph/nodes", "/resources/"}},
+		{name: "duplicate", patterns: []string{"/graph", "/events", "/graph"}, expErr: `"/graph"`},
+		{name: "conflicting", patterns: []string{"/releases/{name}/resources", "/releases/web/{kind}"}, expErr: `"/releases/web/{kind}"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &routeMux{mux: http.NewServeMux()}
+			for _, pattern := range tc.patterns {
+				m.HandleFunc(pattern, noop)
+			}
+
+			if tc.expErr == "" {
+				if m.err != nil {
+					t.Errorf("registration should succeed, got %s", m.err)
+				}
+				return
+			}
+			if m.err == nil || !strings.Contains(m.err.Error(), tc.expErr) {
+				t.Errorf("error should mention %s, got %v", tc.expErr, m.err)
+			}
+		})
+	}
+}
+
+// TestRegisterRoutesTwice checks that registering the routes on a mux that
+// already has them fails with an error instead of a panic.
+func TestRegisterRoutesTwice(t *testing.T) {
+	app := newTestApp(t, `{}`)
+	mux := http.NewServeMux()
+	if err := app.registerRoutes(mux); err != nil {
+		t.Fatalf("registerRoutes error: %s", err)
+	}
+	if err := app.registerRoutes(mux); err == nil {
+		t.Error("registering routes twice should fail")
+	}
+}
+
+// TestDisabledEndpoints checks that disabled routes answer 404 without
+// reaching the state server and are left out of the debug settings.
+func TestDisabledEndpoints(t *testing.T) {
+	server, mux := newFakeStateServerApp(t, `"disabledEndpoints":["resources","unknown"],"enableDebugEndpoints":true`)
+
+	for _, tc := range []struct {
+		path      string
+		expStatus int
+	}{
+		{path: "/resources", expStatus: http.StatusNotFound},
+		{path: "/resources/a", expStatus: http.StatusNotFound},
+		{path: "/graph", expStatus: http.StatusOK},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type should be application/json, got %q", ct)
+			}
+			if requests := server.received(); tc.expStatus == http.StatusNotFound && len(requests) != 0 {
+				t.Errorf("disabled route should not reach the state server, got %d requests", len(requests))
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/settings", nil))
+	var got struct {
+		Routes []string `json:"routes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %s", err)
+	}
+	if slices.Contains(got.Routes, "/resources") || slices.Contains(got.Routes, "/resources/") {
+		t.Errorf("routes should not list the disabled resources routes, got %v", got.Routes)
+	}
+	if !slices.Contains(got.Routes, "/graph") {
+		t.Errorf("routes should list /graph, got %v", got.Routes)
+	}
+}
+
+func TestUnknownPath(t *testing.T) {
+	app := newTestApp(t, `{}`)
+	mux := http.NewServeMux()
+	if err := app.registerRoutes(mux); err != nil {
+		t.Fatalf("registerRoutes error: %s", err)
+	}
+
+	for _, path := range []string{"/bogus", "/graph/bogus"} {
+		t.Run(path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("response status should be %d, got %d", http.StatusNotFound, rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type should be application/json, got %q", ct)
+			}
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("response body should be a JSON error, got %q", rec.Body.String())
+			}
+			if body.Error != "not found" || body.Path != path {
+				t.Errorf("body should report %s as not found, got %q", path, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestRoutePrefix checks that the routes are served under the configured
+// prefix only and that every other path still gets the JSON 404.
+func TestRoutePrefix(t *testing.T) {
+	server, mux := newFakeStateServerApp(t, `"routePrefix":"/api/"`)
+
+	for _, tc := range []struct {
+		path      string
+		expStatus int
+		expPath   string
+	}{
+		{path: "/api/graph", expStatus: http.StatusOK, expPath: "/api/v1/graph"},
+		{path: "/api/resources/a", expStatus: http.StatusOK, expPath: "/api/v1/resources/a"},
+		{path: "/api/releases/web/resources", expStatus: http.StatusOK, expPath: "/api/v1/releases/web/resources"},
+		{path: "/graph", expStatus: http.StatusNotFound},
+		{path: "/api/bogus", expStatus: http.StatusNotFound},
+		{path: "/api/", expStatus: http.StatusNotFound},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			before := len(server.received())
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rec.Code != tc.expStatus {
+				t.Errorf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			requests := server.received()[before:]
+			if tc.expPath == "" {
+				var body errorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Path != tc.path {
+					t.Errorf("body should report %s as not found, got %q", tc.path, rec.Body.String())
+				}
+				if len(requests) != 0 {
+					t.Errorf("state server should receive no request, got %d", len(requests))
+				}
+				return
+			}
+			if len(requests) != 1 || requests[0].Path != tc.expPath {
+				t.Errorf("state server should receive %s, got %v", tc.expPath, requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// schemaFiles holds the JSON schemas of the state server responses the
+// frontend relies on, named after their endpoint.
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// responseSchemas are the parsed schemaFiles keyed by endpoint.
+var responseSchemas = loadSchemas()
+
+// schema is the subset of JSON Schema used to describe upstream responses:
+// the type of a value, the properties and required keys of objects and the
+// items of arrays.
+type schema struct {
+	Type       string             `json:"type"`
+	Properties map[string]*schema `json:"properties"`
+	Required   []string           `json:"required"`
+	Items      *schema            `json:"items"`
+}
+
+// loadSchemas parses the embedded schemas. They are part of the binary, so
+// an invalid one is a programming error.
+func loadSchemas() map[string]*schema {
+	files, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+	schemas := make(map[string]*schema, len(files))
+	for _, file := range files {
+		data, err := schemaFiles.ReadFile("schemas/" + file.Name())
+		if err != nil {
+			panic(err)
+		}
+		var s schema
+		if err := json.Unmarshal(data, &s); err != nil {
+			panic(fmt.Sprintf("invalid schema %s: %v", file.Name(), err))
+		}
+		schemas[strings.TrimSuffix(file.Name(), ".json")] = &s
+	}
+	return schemas
+}
+
+// validateResponse returns a rewrite for rewriteJSONBody that leaves the body
+// as is but fails if it doesn't match the endpoint's schema.
+func validateResponse(endpoint string, s *schema) func([]byte) ([]byte, error) {
+	return func(body []byte) ([]byte, error) {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		if err := s.validate(value, "$"); err != nil {
+			return nil, fmt.Errorf("response does not match the %s schema: %w", endpoint, err)
+		}
+		return body, nil
+	}
+}
+
+// validate checks value, found at path, against the schema.
+func (s *schema) validate(value any, path string) error {
+	if s.Type != "" && jsonType(value, s.Type == "integer") != s.Type {
+		return fmt.Errorf("%s should be %s, got %s", path, s.Type, jsonType(value, false))
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		for _, key := range s.Required {
+			if _, ok := v[key]; !ok {
+				return fmt.Errorf("%s is missing %q", path, key)
+			}
+		}
+		for key, property := range s.Properties {
+			if item, ok := v[key]; ok {
+				if err := property.validate(item, path+"."+key); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonType returns the JSON Schema type of a decoded value. Numbers are
+// reported as integer if asked for and they have no fraction.
+func jsonType(value any, integer bool) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); integer && err == nil {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
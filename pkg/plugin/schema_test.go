@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestValidateResponse checks the embedded schemas against matching and
+// mismatching responses.
+func TestValidateResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		endpoint string
+		body     string
+		expErr   string
+	}{
+		{name: "graph", endpoint: "graph", body: `{"nodes":[{"uid":"1","kind":"Pod","name":"web","metadata":{}}],"edges":[{"from":"1","to":"2","type":"owns"}]}`},
+		{name: "empty graph", endpoint: "graph", body: `{"nodes":[],"edges":[]}`},
+		{name: "graph without edges", endpoint: "graph", body: `{"nodes":[]}`, expErr: `$ is missing "edges"`},
+		{name: "numeric uid", endpoint: "graph", body: `{"nodes":[{"uid":1,"kind":"Pod","name":"web"}],"edges":[]}`, expErr: "$.nodes[0].uid should be string, got number"},
+		{name: "graph as array", endpoint: "graph", body: `[]`, expErr: "$ should be object, got array"},
+		{name: "namespaces", endpoint: "namespaces", body: `["default","kube-system"]`},
+		{name: "namespace objects", endpoint: "namespaces", body: `[{"name":"default"}]`, expErr: "$[0] should be string, got object"},
+		{name: "releases", endpoint: "releases", body: `["web"]`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := validateResponse(tc.endpoint, responseSchemas[tc.endpoint])([]byte(tc.body))
+			if tc.expErr == "" {
+				if err != nil {
+					t.Errorf("response should be valid, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expErr) {
+				t.Errorf("error should contain %q, got %v", tc.expErr, err)
+			}
+		})
+	}
+}
+
+// TestProxyValidatesResponses checks that mismatching responses are rejected
+// with 502 only when validation is enabled.
+func TestProxyValidatesResponses(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"vertices":[]}`))
+	}))
+	defer indexer.Close()
+
+	for _, tc := range []struct {
+		name      string
+		jsonData  string
+		expStatus int
+	}{
+		{name: "disabled", jsonData: `{"indexerUrl":"` + indexer.URL + `"}`, expStatus: http.StatusOK},
+		{name: "enabled", jsonData: `{"indexerUrl":"` + indexer.URL + `","validateResponses":true}`, expStatus: http.StatusBadGateway},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t, tc.jsonData)
+			rec := httptest.NewRecorder()
+			app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+
+			if rec.Code != tc.expStatus {
+				t.Fatalf("response status should be %d, got %d", tc.expStatus, rec.Code)
+			}
+			if tc.expStatus != http.StatusBadGateway {
+				return
+			}
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || !strings.Contains(body.Error, "graph schema") {
+				t.Errorf("response should report the schema mismatch, got %q", rec.Body.String())
+			}
+		})
+	}
+}
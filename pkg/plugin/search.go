@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// searchFallbackLimit caps the resources returned by the search fallback.
+const searchFallbackLimit = 100
+
+// handleSearch proxies a search for resources matching the q param, scoped by
+// the optional namespace and kind params. With fallback=true, state servers
+// without a search endpoint are searched by listing the resources and
+// matching q against their names instead.
+func (a *App) handleSearch(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if query.Get("q") == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing search query")
+		return
+	}
+	if query.Get("fallback") != "true" {
+		a.proxyToIndexer(w, req, "search", "")
+		return
+	}
+
+	search := newSharedResponse()
+	a.proxyToIndexer(search, req, "search", "")
+	if search.status != http.StatusNotFound && search.status != http.StatusNotImplemented {
+		search.replay(w, "")
+		return
+	}
+	a.searchResources(w, req)
+}
+
+// searchResources is the search fallback: it lists the resources page by page
+// and keeps those whose name contains q, ignoring case, up to
+// searchFallbackLimit. X-Search-Truncated is set if more may have matched,
+// including when the list's pages could not all be followed.
+func (a *App) searchResources(w http.ResponseWriter, req *http.Request) {
+	if !a.track() {
+		writeJSONError(w, http.StatusServiceUnavailable, "plugin is shutting down")
+		return
+	}
+	defer a.inFlight.Done()
+
+	indexerURL, err := a.getIndexerURL(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	target, err := a.settings.resolve(indexerURL, a.settings.apiPath("resources", ""))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	query := req.URL.Query()
+	needle := strings.ToLower(query.Get("q"))
+	params := url.Values{"namespace": query["namespace"], "kind": query["kind"]}
+	params, _ = url.ParseQuery(withDefaultNamespace("resources", params.Encode(), a.settings.DefaultNamespace))
+
+	matches := []json.RawMessage{}
+	truncated := false
+	for pages := 1; ; pages++ {
+		target.RawQuery = params.Encode()
+		header, body, err := a.fetch(req.Context(), "resources", target)
+		if err != nil {
+			log.DefaultLogger.Warn("Search fallback failed", "error", err)
+			writeProxyError(w, indexerURL, err)
+			return
+		}
+
+		// The list is either an array or an object holding it in "items"
+		var list struct {
+			Items json.RawMessage `json:"items"`
+		}
+		items := json.RawMessage(body)
+		if err := json.Unmarshal(body, &list); err == nil {
+			items = list.Items
+		}
+		var resources []json.RawMessage
+		if err := json.Unmarshal(items, &resources); err != nil {
+			writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("%v: %v", errInvalidUpstreamResponse, err))
+			return
+		}
+		for _, resource := range resources {
+			var meta struct {
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(resource, &meta); err != nil || !strings.Contains(strings.ToLower(meta.Name), needle) {
+				continue
+			}
+			if len(matches) == searchFallbackLimit {
+				truncated = true
+				break
+			}
+			matches = append(matches, resource)
+		}
+
+		if truncated {
+			break
+		}
+		token, err := nextPageToken(header, params.Get("continue"), pages)
+		if err != nil {
+			log.DefaultLogger.Warn("Search fallback stopped paging", "error", err)
+			truncated = true
+			break
+		}
+		if token == "" {
+			break
+		}
+		params.Set("continue", token)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if truncated {
+		w.Header().Set("X-Search-Truncated", "true")
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(matches); err != nil {
+		log.DefaultLogger.Error("Failed to write search response", "error", err)
+	}
+}
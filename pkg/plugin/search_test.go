@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestHandleSearch checks the search proxy and the fallback to filtering the
+// resource list of state servers without a search endpoint.
+func TestHandleSearch(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/search":
+			if !strings.HasPrefix(r.URL.Query().Get("q"), "missing") {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`[{"kind":"Pod","name":"found"}]`))
+				return
+			}
+			http.NotFound(w, r)
+		case "/api/v1/resources":
+			if r.URL.Query().Get("kind") != "Pod" {
+				t.Errorf("kind should be forwarded to the fallback, got %q", r.URL.Query().Get("kind"))
+			}
+			// Two pages of 150 resources, every other one matching
+			page := 0
+			if r.URL.Query().Get("continue") != "" {
+				page = 1
+			} else {
+				w.Header().Set("X-Continue-Token", "next")
+			}
+			var items []string
+			for i := range 150 {
+				name := fmt.Sprintf("db-%d-%d", page, i)
+				if i%2 == 0 {
+					name = fmt.Sprintf("Missing-%d-%d", page, i)
+				}
+				items = append(items, fmt.Sprintf(`{"kind":"Pod","name":%q}`, name))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"items":[` + strings.Join(items, ",") + `]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer indexer.Close()
+
+	for _, tc := range []struct {
+		name         string
+		path         string
+		expStatus    int
+		expMatches   int
+		expTruncated bool
+	}{
+		{name: "search endpoint", path: "/search?q=found&fallback=true", expStatus: http.StatusOK, expMatches: 1},
+		{name: "search endpoint missing", path: "/search?q=missing&kind=Pod", expStatus: http.StatusNotFound},
+		{name: "fallback", path: "/search?q=missing&kind=Pod&fallback=true", expStatus: http.StatusOK, expMatches: searchFallbackLimit, expTruncated: true},
+		{name: "fallback without truncation", path: "/search?q=missing-1-2&kind=Pod&fallback=true", expStatus: http.StatusOK, expMatches: 6},
+		{name: "missing query", path: "/search?fallback=true", expStatus: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+			rec := httptest.NewRecorder()
+			app.handleSearch(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rec.Code != tc.expStatus {
+				t.Fatalf("status should be %d, got %d: %s", tc.expStatus, rec.Code, rec.Body)
+			}
+			if tc.expStatus != http.StatusOK {
+				return
+			}
+			var matches []map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &matches); err != nil {
+				t.Fatalf("response should be a JSON array: %s", err)
+			}
+			if len(matches) != tc.expMatches {
+				t.Errorf("matches should be %d, got %d", tc.expMatches, len(matches))
+			}
+			if truncated := rec.Header().Get("X-Search-Truncated") == "true"; truncated != tc.expTruncated {
+				t.Errorf("truncated should be %t, got %t", tc.expTruncated, truncated)
+			}
+		})
+	}
+}
+
+// TestSearchFallbackPaging checks that the search fallback stops on a
+// repeated continue token and reports the results as truncated.
+func TestSearchFallbackPaging(t *testing.T) {
+	calls := 0
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/search" {
+			http.NotFound(w, r)
+			return
+		}
+		calls++
+		w.Header().Set("X-Continue-Token", "same")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"kind":"Pod","name":"db"}]`))
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	rec := httptest.NewRecorder()
+	app.handleSearch(rec, httptest.NewRequest(http.MethodGet, "/search?q=db&fallback=true", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status should be %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	if calls != 2 {
+		t.Errorf("calls should be 2, got %d", calls)
+	}
+	if rec.Header().Get("X-Search-Truncated") != "true" {
+		t.Error("results should be truncated")
+	}
+}
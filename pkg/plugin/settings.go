@@ -0,0 +1,519 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/build/buildinfo"
+)
+
+const (
+	// defaultIndexerURL is used when no indexer URL is configured in the app settings.
+	defaultIndexerURL = "http://astrolabe:8080"
+
+	// defaultAPIVersion is the state server API version requests are sent to.
+	defaultAPIVersion = "v1"
+
+	// defaultRequestTimeoutMs bounds proxied requests when no timeout is configured.
+	defaultRequestTimeoutMs = 30000
+
+	// Connection pool defaults for the shared upstream transport.
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+
+	// Connection timeout defaults for the shared upstream transport. Response
+	// headers are only bounded by the request timeout by default.
+	defaultIdleConnTimeoutMs     = 90000
+	defaultTLSHandshakeTimeoutMs = 10000
+
+	// defaultMaxRetries is how often idempotent requests are retried on
+	// transient upstream failures.
+	defaultMaxRetries = 2
+
+	// defaultMaxRetryAfterSeconds caps how long a retry waits for the delay
+	// asked for by an upstream Retry-After header.
+	defaultMaxRetryAfterSeconds = 10
+
+	// defaultMaxRequestBodyBytes caps request bodies relayed upstream.
+	defaultMaxRequestBodyBytes = 1 << 20
+
+	// defaultMaxResponseBytes caps upstream response bodies relayed to
+	// the client.
+	defaultMaxResponseBytes = 50 << 20
+
+	// defaultWarmupTimeoutMs bounds how long a new instance waits for the
+	// state server hosts to resolve.
+	defaultWarmupTimeoutMs = 10000
+
+	// Circuit breaker defaults: open after five consecutive failures and
+	// probe again after 30 seconds.
+	defaultCircuitBreakerThreshold       = 5
+	defaultCircuitBreakerCooldownSeconds = 30
+)
+
+// appSettings holds the plugin settings configured on the AppConfig page.
+type appSettings struct {
+	IndexerURL       string `json:"indexerUrl"`
+	RequestTimeoutMs int    `json:"requestTimeoutMs"`
+
+	// Timeouts overrides RequestTimeoutMs for single endpoints, in
+	// milliseconds and keyed by endpoint, e.g. "graph".
+	Timeouts map[string]int `json:"timeouts"`
+
+	// indexerURL and clusterURLs are the validated forms of IndexerURL and
+	// Clusters that requests are resolved against.
+	indexerURL  *url.URL
+	clusterURLs map[string]*url.URL
+
+	// BasePath is the prefix the state server is mounted under, e.g. when
+	// it sits behind an ingress at /astrolabe.
+	BasePath string `json:"basePath"`
+
+	// Protocol is how the state server is called: "rest", the default, or
+	// "grpcweb" to call the endpoints listed in grpcWebMethods over gRPC-Web.
+	// The other endpoints are still served by the REST API.
+	Protocol string `json:"protocol"`
+
+	// APIVersion is the state server API version substituted into the
+	// upstream paths listed in apiPaths, e.g. "v1" for /api/v1/graph.
+	APIVersion string `json:"apiVersion"`
+
+	// Clusters maps cluster names to the URL of their state server, selected
+	// with the "cluster" query parameter.
+	Clusters map[string]string `json:"clusters"`
+
+	// DefaultNamespace is sent to endpoints accepting a namespace param when
+	// the request doesn't select one.
+	DefaultNamespace string `json:"defaultNamespace"`
+
+	// WarmupTimeoutMs bounds how long requests made right after the plugin
+	// started wait for the state server hosts to resolve. Zero disables the
+	// warm-up.
+	WarmupTimeoutMs int `json:"warmupTimeoutMs"`
+
+	// EnableH2C makes plaintext http state servers be spoken to over HTTP/2
+	// with prior knowledge (h2c). It requires all state servers to support
+	// HTTP/2.
+	EnableH2C bool `json:"enableH2C"`
+
+	// Connection pool sizes of the shared upstream transport.
+	MaxIdleConns        int `json:"maxIdleConns"`
+	MaxIdleConnsPerHost int `json:"maxIdleConnsPerHost"`
+
+	// Connection timeouts of the shared upstream transport: how long idle
+	// connections are kept, how long the TLS handshake and the response
+	// headers may take. Zero means no limit; negative values are rejected.
+	IdleConnTimeoutMs       int `json:"idleConnTimeoutMs"`
+	TLSHandshakeTimeoutMs   int `json:"tlsHandshakeTimeoutMs"`
+	ResponseHeaderTimeoutMs int `json:"responseHeaderTimeoutMs"`
+
+	// MaxRetries bounds retries of GET/HEAD requests. Zero disables retries.
+	MaxRetries int `json:"maxRetries"`
+
+	// MaxRetryAfterSeconds caps the Retry-After delay of 429 and 503
+	// responses honored before retrying.
+	MaxRetryAfterSeconds int `json:"maxRetryAfterSeconds"`
+
+	// CacheTTLSeconds enables caching of the namespaces and releases lists
+	// and of graphs, which are refreshed in the background at this interval.
+	// Zero disables the cache.
+	CacheTTLSeconds int `json:"cacheTtlSeconds"`
+
+	// MaxRequestBodyBytes is the largest request body relayed upstream.
+	// Larger requests are rejected with 413.
+	MaxRequestBodyBytes int64 `json:"maxRequestBodyBytes"`
+
+	// MaxResponseBytes is the largest upstream response body relayed to the
+	// client, streams aside. Larger responses fail with 502.
+	MaxResponseBytes int64 `json:"maxResponseBytes"`
+
+	// CircuitBreakerThreshold is the number of consecutive upstream failures
+	// after which requests fail fast for CircuitBreakerCooldownSeconds. Zero
+	// disables the circuit breaker.
+	CircuitBreakerThreshold       int `json:"circuitBreakerThreshold"`
+	CircuitBreakerCooldownSeconds int `json:"circuitBreakerCooldownSeconds"`
+
+	// OrgNamespaceAllowlist restricts the namespaces Grafana orgs may view
+	// on the graph and resource list endpoints, keyed by org ID. Orgs that
+	// are not listed may view all namespaces.
+	OrgNamespaceAllowlist map[int64][]string `json:"orgNamespaceAllowlist"`
+
+	// AllowedOrigins lists the origins allowed to call the proxy routes
+	// cross-origin. "*" allows any origin but must be listed explicitly.
+	AllowedOrigins []string `json:"allowedOrigins"`
+
+	// MaxConcurrentUpstream caps the requests in flight to the state
+	// servers, whether proxied or made by the plugin itself, event streams
+	// aside. Requests waiting too long for a free slot are rejected with 503.
+	// Zero means unlimited.
+	MaxConcurrentUpstream int `json:"maxConcurrentUpstream"`
+
+	// UserAgent is sent with every upstream request. It defaults to
+	// astrolabe-grafana/<plugin version>.
+	UserAgent string `json:"userAgent"`
+
+	// LogSampleRate logs only one in every LogSampleRate proxied requests.
+	// Failed requests are always logged. Zero or one logs every request.
+	LogSampleRate int `json:"logSampleRate"`
+
+	// RoutePrefix is prepended to the patterns of all resource routes, e.g.
+	// "/api" to serve /api/graph. It is normalized to a leading slash and no
+	// trailing one.
+	RoutePrefix string `json:"routePrefix"`
+
+	// DisabledEndpoints lists the routes that answer 404 instead of being
+	// served, named by their path without slashes, e.g. "resources" or
+	// "graph/stream".
+	DisabledEndpoints []string `json:"disabledEndpoints"`
+
+	// FollowRedirects follows the redirects of the state server to itself
+	// instead of relaying them to the client, whose redirect Location would
+	// otherwise be rewritten to the matching plugin route.
+	FollowRedirects bool `json:"followRedirects"`
+
+	// StreamCoalesceMs is the window in which the updates of the graph Live
+	// stream are merged into one, so bursts don't flood the browser. Zero
+	// publishes every update.
+	StreamCoalesceMs int `json:"streamCoalesceMs"`
+
+	// HealthPollSeconds is how often the state server health is checked in
+	// the background from startup, logging when it becomes reachable or
+	// unreachable. The results also back /ready. Zero checks only once /ready
+	// is polled.
+	HealthPollSeconds int `json:"healthPollSeconds"`
+
+	// UnwrapField is the field extracted from enveloped JSON responses, e.g.
+	// "data", unless the request has its own unwrap param. Empty relays the
+	// responses as they are.
+	UnwrapField string `json:"unwrapField"`
+
+	// ResponseHeaders lists upstream response headers relayed to the client
+	// on top of defaultResponseHeaders. All others are dropped.
+	ResponseHeaders []string `json:"responseHeaders"`
+
+	// responseHeaders is the full allowlist of canonical header names.
+	responseHeaders []string
+
+	// ForwardCookies lists the names of the cookies relayed to the state
+	// server, e.g. the session cookie of an auth proxy in front of it. All
+	// other cookies are stripped.
+	ForwardCookies []string `json:"forwardCookies"`
+
+	// UpstreamHeaders are static headers added to every upstream request,
+	// e.g. a tenant ID. "${env:VAR}" in a value is replaced by the variable
+	// from the plugin's environment. Headers set by the plugin itself, such
+	// as Authorization and the trace headers, cannot be configured.
+	UpstreamHeaders map[string]string `json:"upstreamHeaders"`
+
+	// upstreamHeaders is UpstreamHeaders with the values expanded.
+	upstreamHeaders http.Header
+
+	// MaxRequestsPerSecond limits the rate of proxied requests. Zero means
+	// unlimited.
+	MaxRequestsPerSecond float64 `json:"maxRequestsPerSecond"`
+
+	// ValidateResponses checks the responses of the endpoints the frontend
+	// relies on against their embedded schema and rejects mismatches with
+	// 502, surfacing state server version skew.
+	ValidateResponses bool `json:"validateResponses"`
+
+	// EnableDebugEndpoints exposes /debug/settings, which shows the
+	// effective settings with secrets redacted.
+	EnableDebugEndpoints bool `json:"enableDebugEndpoints"`
+
+	// IndexerCACert is a PEM bundle used to verify an https state server.
+	// It may be set in jsonData or, taking precedence, secureJsonData.
+	IndexerCACert             string `json:"indexerCaCert"`
+	IndexerInsecureSkipVerify bool   `json:"indexerInsecureSkipVerify"`
+
+	// IndexerToken is read from secureJsonData and sent as a bearer token
+	// to the state server. It must never be logged.
+	IndexerToken string `json:"-"`
+
+	// IndexerUsername and IndexerPassword are read from secureJsonData and
+	// sent as basic auth credentials unless a token is configured. They must
+	// never be logged.
+	IndexerUsername string `json:"-"`
+	IndexerPassword string `json:"-"`
+
+	// IndexerClientCert and IndexerClientKey are a PEM pair read from
+	// secureJsonData and presented to state servers requiring mutual TLS.
+	IndexerClientCert string `json:"-"`
+	IndexerClientKey  string `json:"-"`
+}
+
+// loadSettings parses the app instance jsonData and applies defaults for
+// values that were left empty.
+func loadSettings(s backend.AppInstanceSettings) (*appSettings, error) {
+	// Defaults for settings where zero is a meaningful value are set before
+	// unmarshalling so that they only apply when the field is absent.
+	settings := &appSettings{
+		MaxRetries:              defaultMaxRetries,
+		CircuitBreakerThreshold: defaultCircuitBreakerThreshold,
+		WarmupTimeoutMs:         defaultWarmupTimeoutMs,
+		IdleConnTimeoutMs:       defaultIdleConnTimeoutMs,
+		TLSHandshakeTimeoutMs:   defaultTLSHandshakeTimeoutMs,
+	}
+	if len(s.JSONData) > 0 {
+		if err := json.Unmarshal(s.JSONData, settings); err != nil {
+			return nil, fmt.Errorf("failed to parse app settings: %w", err)
+		}
+	}
+
+	settings.IndexerToken = s.DecryptedSecureJSONData["indexerToken"]
+	settings.IndexerUsername = s.DecryptedSecureJSONData["indexerUsername"]
+	settings.IndexerPassword = s.DecryptedSecureJSONData["indexerPassword"]
+	settings.IndexerClientCert = s.DecryptedSecureJSONData["indexerClientCert"]
+	settings.IndexerClientKey = s.DecryptedSecureJSONData["indexerClientKey"]
+	if caCert := s.DecryptedSecureJSONData["indexerCaCert"]; caCert != "" {
+		settings.IndexerCACert = caCert
+	}
+
+	if settings.IndexerURL == "" {
+		settings.IndexerURL = defaultIndexerURL
+	}
+	if settings.APIVersion == "" {
+		settings.APIVersion = defaultAPIVersion
+	}
+	switch settings.Protocol {
+	case "":
+		settings.Protocol = protocolREST
+	case protocolREST, protocolGRPCWeb:
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q, must be %q or %q", settings.Protocol, protocolREST, protocolGRPCWeb)
+	}
+	if settings.UserAgent == "" {
+		settings.UserAgent = defaultUserAgent()
+	}
+	if settings.RequestTimeoutMs <= 0 {
+		settings.RequestTimeoutMs = defaultRequestTimeoutMs
+	}
+	if settings.MaxIdleConns <= 0 {
+		settings.MaxIdleConns = defaultMaxIdleConns
+	}
+	if settings.MaxIdleConnsPerHost <= 0 {
+		settings.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	for name, timeout := range map[string]int{
+		"idleConnTimeoutMs":       settings.IdleConnTimeoutMs,
+		"tlsHandshakeTimeoutMs":   settings.TLSHandshakeTimeoutMs,
+		"responseHeaderTimeoutMs": settings.ResponseHeaderTimeoutMs,
+	} {
+		if timeout < 0 {
+			return nil, fmt.Errorf("invalid %s %d: must not be negative", name, timeout)
+		}
+	}
+	if settings.MaxRequestBodyBytes <= 0 {
+		settings.MaxRequestBodyBytes = defaultMaxRequestBodyBytes
+	}
+	if settings.MaxResponseBytes <= 0 {
+		settings.MaxResponseBytes = defaultMaxResponseBytes
+	}
+	if settings.WarmupTimeoutMs < 0 {
+		settings.WarmupTimeoutMs = 0
+	}
+	if settings.MaxRetryAfterSeconds <= 0 {
+		settings.MaxRetryAfterSeconds = defaultMaxRetryAfterSeconds
+	}
+	if settings.MaxRetries < 0 {
+		settings.MaxRetries = 0
+	}
+	if settings.CircuitBreakerThreshold < 0 {
+		settings.CircuitBreakerThreshold = 0
+	}
+	if settings.CircuitBreakerCooldownSeconds <= 0 {
+		settings.CircuitBreakerCooldownSeconds = defaultCircuitBreakerCooldownSeconds
+	}
+	if settings.MaxConcurrentUpstream < 0 {
+		settings.MaxConcurrentUpstream = 0
+	}
+	if settings.LogSampleRate < 0 {
+		settings.LogSampleRate = 0
+	}
+	if settings.StreamCoalesceMs < 0 {
+		settings.StreamCoalesceMs = 0
+	}
+	if settings.HealthPollSeconds < 0 {
+		settings.HealthPollSeconds = 0
+	}
+	if settings.CacheTTLSeconds < 0 {
+		settings.CacheTTLSeconds = 0
+	}
+	if settings.RoutePrefix = strings.Trim(settings.RoutePrefix, "/"); settings.RoutePrefix != "" {
+		if strings.ContainsAny(settings.RoutePrefix, "{} \t") {
+			return nil, fmt.Errorf("invalid route prefix %q: must be a plain path", settings.RoutePrefix)
+		}
+		settings.RoutePrefix = "/" + settings.RoutePrefix
+	}
+	var err error
+	if settings.indexerURL, err = parseIndexerURL(settings.IndexerURL); err != nil {
+		return nil, fmt.Errorf("invalid indexer URL: %w", err)
+	}
+	settings.clusterURLs = make(map[string]*url.URL, len(settings.Clusters))
+	for name, clusterURL := range settings.Clusters {
+		if settings.clusterURLs[name], err = parseIndexerURL(clusterURL); err != nil {
+			return nil, fmt.Errorf("invalid URL for cluster %q: %w", name, err)
+		}
+	}
+
+	if settings.upstreamHeaders, err = expandUpstreamHeaders(settings.UpstreamHeaders); err != nil {
+		return nil, err
+	}
+	settings.responseHeaders = responseHeaderAllowlist(settings.ResponseHeaders)
+
+	return settings, nil
+}
+
+// reservedUpstreamHeaders are set on upstream requests by the plugin and may
+// not be configured in UpstreamHeaders.
+var reservedUpstreamHeaders = append([]string{
+	"Authorization", "Host", "User-Agent", grafanaUserHeader, grafanaOrgIDHeader,
+}, traceHeaders...)
+
+// envReference matches the "${env:VAR}" references of UpstreamHeaders values.
+var envReference = regexp.MustCompile(`\$\{env:([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandUpstreamHeaders validates the configured upstream headers and expands
+// the environment variables referenced by their values. Unset variables
+// expand to the empty string.
+func expandUpstreamHeaders(headers map[string]string) (http.Header, error) {
+	expanded := make(http.Header, len(headers))
+	for name, value := range headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:\"(),/;<=>?@[\\]{}") {
+			return nil, fmt.Errorf("invalid upstream header name %q", name)
+		}
+		if slices.ContainsFunc(reservedUpstreamHeaders, func(h string) bool { return strings.EqualFold(h, name) }) {
+			return nil, fmt.Errorf("upstream header %q is set by the plugin and cannot be configured", name)
+		}
+		value = envReference.ReplaceAllStringFunc(value, func(ref string) string {
+			return os.Getenv(envReference.FindStringSubmatch(ref)[1])
+		})
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid value for upstream header %q", name)
+		}
+		expanded.Set(name, value)
+	}
+	return expanded, nil
+}
+
+// defaultUserAgent identifies the plugin and its version.
+func defaultUserAgent() string {
+	return "astrolabe-grafana/" + pluginVersion()
+}
+
+// pluginVersion returns the version injected into the build info when the
+// plugin is built, or "dev".
+func pluginVersion() string {
+	if info, err := buildinfo.GetBuildInfo(); err == nil && info.Version != "" {
+		return info.Version
+	}
+	return "dev"
+}
+
+// parseIndexerURL parses a state server URL, which must be an absolute http or
+// https URL. IPv6 hosts must be bracketed, as in http://[::1]:8080. The path
+// is given a trailing slash so that API paths resolve below it.
+//
+// A state server listening on a Unix domain socket is given as
+// unix:///path/to/socket, see parseUnixSocketURL. Only the form of the path is
+// checked here since the socket may not exist until the state server starts;
+// CheckHealth reports sockets that cannot be dialed.
+func parseIndexerURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "unix" {
+		return parseUnixSocketURL(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%q must start with http://, https:// or unix://", raw)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%q has no host", raw)
+	}
+	if strings.Count(u.Host, ":") > 1 && !strings.HasPrefix(u.Host, "[") {
+		return nil, fmt.Errorf("%q has an IPv6 host that is not in brackets", raw)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+		if u.RawPath != "" {
+			u.RawPath += "/"
+		}
+	}
+	return u, nil
+}
+
+// requestTimeout returns the configured upstream request timeout.
+func (s *appSettings) requestTimeout() time.Duration {
+	return time.Duration(s.RequestTimeoutMs) * time.Millisecond
+}
+
+// endpointTimeout returns the upstream request timeout of an endpoint, which
+// is the global one unless overridden in Timeouts.
+func (s *appSettings) endpointTimeout(endpoint string) time.Duration {
+	if ms := s.Timeouts[endpoint]; ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return s.requestTimeout()
+}
+
+// upstreamPath prefixes an API path with the configured base path. Leading
+// and trailing slashes of the base path are normalized.
+func (s *appSettings) upstreamPath(path string) string {
+	base := strings.Trim(s.BasePath, "/")
+	if base == "" {
+		return path
+	}
+	return "/" + base + path
+}
+
+// resolve resolves an escaped API path, prefixed with the base path, against
+// a state server URL.
+func (s *appSettings) resolve(base *url.URL, path string) (*url.URL, error) {
+	ref, err := url.Parse("." + s.upstreamPath(path))
+	if err != nil {
+		return nil, err
+	}
+	return base.ResolveReference(ref), nil
+}
+
+// warmupTimeout returns how long to wait for the state server hosts to
+// resolve at startup.
+func (s *appSettings) warmupTimeout() time.Duration {
+	return time.Duration(s.WarmupTimeoutMs) * time.Millisecond
+}
+
+// maxRetryAfter returns the longest Retry-After delay honored.
+func (s *appSettings) maxRetryAfter() time.Duration {
+	return time.Duration(s.MaxRetryAfterSeconds) * time.Second
+}
+
+// circuitBreakerCooldown returns how long the circuit stays open.
+func (s *appSettings) circuitBreakerCooldown() time.Duration {
+	return time.Duration(s.CircuitBreakerCooldownSeconds) * time.Second
+}
+
+// streamCoalesceWindow returns the window graph stream updates are coalesced
+// in.
+func (s *appSettings) streamCoalesceWindow() time.Duration {
+	return time.Duration(s.StreamCoalesceMs) * time.Millisecond
+}
+
+// healthPollInterval returns how often the state server health is polled.
+func (s *appSettings) healthPollInterval() time.Duration {
+	return time.Duration(s.HealthPollSeconds) * time.Second
+}
+
+// cacheTTL returns how long cached responses stay fresh.
+func (s *appSettings) cacheTTL() time.Duration {
+	return time.Duration(s.CacheTTLSeconds) * time.Second
+}
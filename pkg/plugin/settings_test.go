@@ -0,0 +1,275 @@
+package plugin
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestUpstreamPath(t *testing.T) {
+	for _, tc := range []struct {
+		basePath string
+		expPath  string
+	}{
+		{basePath: "", expPath: "/api/v1/graph"},
+		{basePath: "/", expPath: "/api/v1/graph"},
+		{basePath: "/astrolabe", expPath: "/astrolabe/api/v1/graph"},
+		{basePath: "astrolabe/", expPath: "/astrolabe/api/v1/graph"},
+		{basePath: "/astrolabe/", expPath: "/astrolabe/api/v1/graph"},
+		{basePath: "tools/astrolabe", expPath: "/tools/astrolabe/api/v1/graph"},
+	} {
+		t.Run(tc.basePath, func(t *testing.T) {
+			s := &appSettings{BasePath: tc.basePath}
+			if got := s.upstreamPath("/api/v1/graph"); got != tc.expPath {
+				t.Errorf("upstream path should be %q, got %q", tc.expPath, got)
+			}
+		})
+	}
+}
+
+// TestLoadSettingsValidatesIndexerURL checks that indexer URLs without an
+// http(s) scheme or a host are rejected when settings load.
+func TestLoadSettingsValidatesIndexerURL(t *testing.T) {
+	for _, tc := range []struct {
+		indexerURL string
+		expErr     bool
+	}{
+		{indexerURL: "http://astrolabe:8080", expErr: false},
+		{indexerURL: "https://astrolabe.example.com/prefix", expErr: false},
+		{indexerURL: "astrolabe:8080", expErr: true},
+		{indexerURL: "ftp://astrolabe", expErr: true},
+		{indexerURL: "http://", expErr: true},
+		{indexerURL: "http://astrolabe:port", expErr: true},
+		{indexerURL: "http://[fe80::1]:8080", expErr: false},
+		{indexerURL: "http://host:8443", expErr: false},
+		{indexerURL: "http://fe80::1:8080", expErr: true},
+		{indexerURL: "http://:8080", expErr: true},
+		{indexerURL: "unix:///var/run/astrolabe.sock", expErr: false},
+		{indexerURL: "unix://astrolabe/var/run/astrolabe.sock", expErr: true},
+		{indexerURL: "unix:astrolabe.sock", expErr: true},
+		{indexerURL: "unix:///var/run/../astrolabe.sock", expErr: true},
+	} {
+		t.Run(tc.indexerURL, func(t *testing.T) {
+			_, err := loadSettings(backend.AppInstanceSettings{
+				JSONData: []byte(`{"clusters":{"edge":"` + tc.indexerURL + `"}}`),
+			})
+			if gotErr := err != nil; gotErr != tc.expErr {
+				t.Errorf("error should be %t, got %v", tc.expErr, err)
+			}
+		})
+	}
+}
+
+// TestResolve checks that API paths resolve below the indexer URL's own path.
+func TestResolve(t *testing.T) {
+	for _, tc := range []struct {
+		indexerURL string
+		basePath   string
+		expURL     string
+	}{
+		{indexerURL: "http://astrolabe:8080", expURL: "http://astrolabe:8080/api/v1/graph"},
+		{indexerURL: "http://astrolabe:8080/", expURL: "http://astrolabe:8080/api/v1/graph"},
+		{indexerURL: "http://gateway/astrolabe", expURL: "http://gateway/astrolabe/api/v1/graph"},
+		{indexerURL: "http://gateway/astrolabe", basePath: "/v2", expURL: "http://gateway/astrolabe/v2/api/v1/graph"},
+		{indexerURL: "http://[fe80::1]:8080", expURL: "http://[fe80::1]:8080/api/v1/graph"},
+		{indexerURL: "http://[fe80::1%25eth0]:8080/astrolabe", expURL: "http://[fe80::1%25eth0]:8080/astrolabe/api/v1/graph"},
+		{indexerURL: "https://[::1]", basePath: "/v2", expURL: "https://[::1]/v2/api/v1/graph"},
+		{indexerURL: "http://host:8443", expURL: "http://host:8443/api/v1/graph"},
+		{indexerURL: "https://host:8443/astrolabe/", basePath: "v2", expURL: "https://host:8443/astrolabe/v2/api/v1/graph"},
+	} {
+		t.Run(tc.indexerURL+tc.basePath, func(t *testing.T) {
+			base, err := parseIndexerURL(tc.indexerURL)
+			if err != nil {
+				t.Fatalf("parseIndexerURL error: %s", err)
+			}
+			s := &appSettings{BasePath: tc.basePath}
+			got, err := s.resolve(base, "/api/v1/graph")
+			if err != nil {
+				t.Fatalf("resolve error: %s", err)
+			}
+			if got.String() != tc.expURL {
+				t.Errorf("URL should be %q, got %q", tc.expURL, got)
+			}
+		})
+	}
+}
+
+// TestLoadSettings checks the defaults applied to empty settings and that
+// jsonData and secureJsonData override them.
+func TestLoadSettings(t *testing.T) {
+	defaults := appSettings{
+		IndexerURL:                    defaultIndexerURL,
+		Protocol:                      protocolREST,
+		APIVersion:                    defaultAPIVersion,
+		UserAgent:                     defaultUserAgent(),
+		RequestTimeoutMs:              defaultRequestTimeoutMs,
+		MaxIdleConns:                  defaultMaxIdleConns,
+		MaxIdleConnsPerHost:           defaultMaxIdleConnsPerHost,
+		MaxRetries:                    defaultMaxRetries,
+		MaxRetryAfterSeconds:          defaultMaxRetryAfterSeconds,
+		MaxRequestBodyBytes:           defaultMaxRequestBodyBytes,
+		MaxResponseBytes:              defaultMaxResponseBytes,
+		CircuitBreakerThreshold:       defaultCircuitBreakerThreshold,
+		CircuitBreakerCooldownSeconds: defaultCircuitBreakerCooldownSeconds,
+		WarmupTimeoutMs:               defaultWarmupTimeoutMs,
+		IdleConnTimeoutMs:             defaultIdleConnTimeoutMs,
+		TLSHandshakeTimeoutMs:         defaultTLSHandshakeTimeoutMs,
+		responseHeaders:               responseHeaderAllowlist(nil),
+	}
+
+	for _, tc := range []struct {
+		name     string
+		jsonData string
+		secure   map[string]string
+		expErr   bool
+		modify   func(s *appSettings)
+	}{
+		{name: "empty", modify: func(s *appSettings) {}},
+		{name: "empty object", jsonData: `{}`, modify: func(s *appSettings) {}},
+		{
+			name:     "overrides",
+			jsonData: `{"indexerUrl":"https://astrolabe.example.com","basePath":"/astrolabe","apiVersion":"v2","userAgent":"dashboards/1.0","requestTimeoutMs":5000,"timeouts":{"graph":60000},"cacheTtlSeconds":60,"maxRetries":0}`,
+			modify: func(s *appSettings) {
+				s.IndexerURL = "https://astrolabe.example.com"
+				s.BasePath = "/astrolabe"
+				s.APIVersion = "v2"
+				s.UserAgent = "dashboards/1.0"
+				s.RequestTimeoutMs = 5000
+				s.Timeouts = map[string]int{"graph": 60000}
+				s.CacheTTLSeconds = 60
+				s.MaxRetries = 0
+			},
+		},
+		{
+			name:     "negative values",
+			jsonData: `{"requestTimeoutMs":-1,"cacheTtlSeconds":-1,"maxRetries":-1,"circuitBreakerThreshold":-1}`,
+			modify: func(s *appSettings) {
+				s.MaxRetries = 0
+				s.CircuitBreakerThreshold = 0
+			},
+		},
+		{
+			name:     "secure data",
+			jsonData: `{"indexerCaCert":"plain"}`,
+			secure:   map[string]string{"indexerToken": "s3cret", "indexerUsername": "grafana", "indexerPassword": "pa55", "indexerCaCert": "secure"},
+			modify: func(s *appSettings) {
+				s.IndexerToken = "s3cret"
+				s.IndexerUsername = "grafana"
+				s.IndexerPassword = "pa55"
+				s.IndexerCACert = "secure"
+			},
+		},
+		{name: "grpcweb protocol", jsonData: `{"protocol":"grpcweb"}`, modify: func(s *appSettings) { s.Protocol = protocolGRPCWeb }},
+		{
+			name:     "response headers",
+			jsonData: `{"responseHeaders":["x-tenant"]}`,
+			modify: func(s *appSettings) {
+				s.ResponseHeaders = []string{"x-tenant"}
+				s.responseHeaders = append(responseHeaderAllowlist(nil), "X-Tenant")
+			},
+		},
+		{
+			name:     "transport timeouts",
+			jsonData: `{"idleConnTimeoutMs":0,"tlsHandshakeTimeoutMs":5000,"responseHeaderTimeoutMs":15000}`,
+			modify: func(s *appSettings) {
+				s.IdleConnTimeoutMs, s.TLSHandshakeTimeoutMs, s.ResponseHeaderTimeoutMs = 0, 5000, 15000
+			},
+		},
+		{name: "negative transport timeout", jsonData: `{"responseHeaderTimeoutMs":-1}`, expErr: true},
+		{name: "route prefix", jsonData: `{"routePrefix":"api/"}`, modify: func(s *appSettings) { s.RoutePrefix = "/api" }},
+		{name: "invalid route prefix", jsonData: `{"routePrefix":"/{org}"}`, expErr: true},
+		{name: "unsupported protocol", jsonData: `{"protocol":"soap"}`, expErr: true},
+		{name: "invalid json", jsonData: `{"requestTimeoutMs":"fast"}`, expErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := loadSettings(backend.AppInstanceSettings{
+				JSONData:                []byte(tc.jsonData),
+				DecryptedSecureJSONData: tc.secure,
+			})
+			if tc.expErr {
+				if err == nil {
+					t.Error("loadSettings should fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadSettings error: %s", err)
+			}
+
+			exp := defaults
+			tc.modify(&exp)
+			// The parsed URLs are covered by TestLoadSettingsValidatesIndexerURL
+			// and the expanded headers by TestExpandUpstreamHeaders
+			got.indexerURL, got.clusterURLs, got.upstreamHeaders = nil, nil, nil
+			if !reflect.DeepEqual(*got, exp) {
+				t.Errorf("settings should be\n%+v\ngot\n%+v", exp, *got)
+			}
+		})
+	}
+}
+
+func TestExpandUpstreamHeaders(t *testing.T) {
+	t.Setenv("ASTROLABE_TENANT", "acme")
+
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		exp     http.Header
+		expErr  string
+	}{
+		{
+			name:    "static",
+			headers: map[string]string{"x-env": "prod"},
+			exp:     http.Header{"X-Env": {"prod"}},
+		},
+		{
+			name:    "env",
+			headers: map[string]string{"X-Tenant-ID": "tenant-${env:ASTROLABE_TENANT}"},
+			exp:     http.Header{"X-Tenant-Id": {"tenant-acme"}},
+		},
+		{
+			name:    "unset env",
+			headers: map[string]string{"X-Tenant-ID": "${env:ASTROLABE_UNSET}"},
+			exp:     http.Header{"X-Tenant-Id": {""}},
+		},
+		{
+			name:    "not an env reference",
+			headers: map[string]string{"X-Literal": "${HOME} $env:HOME"},
+			exp:     http.Header{"X-Literal": {"${HOME} $env:HOME"}},
+		},
+		{
+			name:    "reserved",
+			headers: map[string]string{"authorization": "Bearer x"},
+			expErr:  "cannot be configured",
+		},
+		{
+			name:    "trace header",
+			headers: map[string]string{"Traceparent": "00-abc"},
+			expErr:  "cannot be configured",
+		},
+		{
+			name:    "invalid name",
+			headers: map[string]string{"X Tenant": "acme"},
+			expErr:  "invalid upstream header name",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := expandUpstreamHeaders(tc.headers)
+			if tc.expErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expErr) {
+					t.Errorf("error should contain %q, got %v", tc.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandUpstreamHeaders error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.exp) {
+				t.Errorf("headers should be %v, got %v", tc.exp, got)
+			}
+		})
+	}
+}
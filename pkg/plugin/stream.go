@@ -0,0 +1,341 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Live stream paths. Grafana scopes them under the plugin's channel
+// namespace, e.g. plugin/<plugin id>/graph. It runs a single RunStream per
+// path however many clients subscribe, so subscribers share one upstream
+// watch, stopped once the last of them leaves.
+const (
+	// graphStreamPath carries graph updates.
+	graphStreamPath = "graph"
+
+	// resourceStreamPrefix is followed by a namespace and carries the
+	// updates of the resources in that namespace, e.g. pod status changes.
+	resourceStreamPrefix = "resources/"
+)
+
+const (
+	// Backoff between reconnects to the state server's watch endpoint.
+	streamInitialBackoff = time.Second
+	streamMaxBackoff     = 30 * time.Second
+
+	// streamMaxFailures is the number of consecutive reconnects without any
+	// update after which the stream gives up.
+	streamMaxFailures = 10
+
+	// maxStreamFrameBytes bounds a single update received from the watch.
+	maxStreamFrameBytes = 16 << 20
+)
+
+// resourceStreamNamespace returns the namespace of a resource stream path.
+func resourceStreamNamespace(path string) (string, bool) {
+	namespace, ok := strings.CutPrefix(path, resourceStreamPrefix)
+	return namespace, ok && namespace != "" && !strings.Contains(namespace, "/")
+}
+
+// SubscribeStream allows subscriptions to the resource streams of the
+// namespaces the org may view, and to the graph stream for orgs without a
+// namespace allowlist.
+func (a *App) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	namespace, isResources := resourceStreamNamespace(req.Path)
+	if req.Path != graphStreamPath && !isResources {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	if a.settingsErr != nil {
+		return nil, a.settingsErr
+	}
+	// The graph stream spans every namespace, so restricted orgs only get
+	// the resource streams of their namespaces
+	if allowed, restricted := a.settings.allowedNamespaces(req.PluginContext.OrgID); restricted && (!isResources || !slices.Contains(allowed, namespace)) {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusPermissionDenied}, nil
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream rejects all publications: the streams are only fed by the
+// state server.
+func (a *App) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream watches the state server for graph or resource changes and
+// republishes every update on the stream until the last subscriber leaves.
+// With streamCoalesceMs set, bursts of graph updates are merged, see
+// streamCoalescer. Upstream disconnects are retried with jittered exponential
+// backoff, see streamBackoff, so subscribers only get an error once
+// streamMaxFailures reconnects in a row failed to receive anything.
+func (a *App) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	endpoint, query := "graph_watch", ""
+	if namespace, ok := resourceStreamNamespace(req.Path); ok {
+		endpoint, query = "resource_watch", url.Values{"namespace": {namespace}}.Encode()
+	} else if req.Path != graphStreamPath {
+		return fmt.Errorf("unknown stream path %q", req.Path)
+	}
+
+	failures := 0
+	for {
+		received, err := a.watch(ctx, sender, endpoint, query)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if received > 0 {
+			failures = 0
+		}
+		failures++
+		if failures >= streamMaxFailures {
+			log.DefaultLogger.Error("Watch failed persistently, giving up", "stream", req.Path, "error", err, "attempts", failures)
+			if msg, mErr := json.Marshal(errorResponse{Error: req.Path + " stream unavailable", Detail: err.Error()}); mErr == nil {
+				_ = sender.SendJSON(msg)
+			}
+			return fmt.Errorf("%s watch failed %d times in a row: %w", req.Path, streamMaxFailures, err)
+		}
+
+		backoff := a.reconnectBackoff(failures)
+		log.DefaultLogger.Warn("Watch disconnected, reconnecting", "stream", req.Path, "error", err, "backoff", backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// streamBackoff returns the delay before the reconnect following the given
+// number of consecutive failures. It doubles from streamInitialBackoff up to
+// streamMaxBackoff, and jitter, in [0, 1), picks a delay between half of it
+// and all of it so that instances don't reconnect in lockstep.
+func streamBackoff(failures int, jitter float64) time.Duration {
+	backoff := streamMaxBackoff
+	if shift := failures - 1; shift < 16 {
+		backoff = min(streamInitialBackoff<<shift, streamMaxBackoff)
+	}
+	return backoff/2 + time.Duration(jitter*float64(backoff/2))
+}
+
+// watch opens a watch endpoint of the state server with the given query and
+// sends every received update, one JSON document per line, to the stream. It
+// returns once the watch ends, with any coalesced update sent, along with the
+// number of updates received.
+func (a *App) watch(ctx context.Context, sender *backend.StreamSender, endpoint, query string) (received int, err error) {
+	target, err := a.settings.resolve(a.settings.indexerURL, a.settings.apiPath(endpoint, ""))
+	if err != nil {
+		return 0, err
+	}
+	target.RawQuery = query
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	a.setUpstreamHeaders(req)
+	a.authorize(req)
+
+	resp, err := a.streamClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("astrolabe server returned %s", resp.Status)
+	}
+
+	// Only graph frames can be merged; every resource update is a change of
+	// its own resource
+	send := sender.SendJSON
+	if window := a.settings.streamCoalesceWindow(); window > 0 && endpoint == "graph_watch" {
+		coalescer := &streamCoalescer{window: window, send: sender.SendJSON}
+		send = coalescer.push
+		defer func() {
+			if flushErr := coalescer.close(); flushErr != nil {
+				err = flushErr
+			}
+		}()
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, maxStreamFrameBytes)
+	for scanner.Scan() {
+		update := bytes.TrimSpace(scanner.Bytes())
+		if len(update) == 0 {
+			continue
+		}
+		if err := send(update); err != nil {
+			return received, err
+		}
+		received++
+	}
+	if err := scanner.Err(); err != nil {
+		return received, err
+	}
+	return received, errors.New("watch closed by astrolabe server")
+}
+
+// streamFrame is an update of the graph watch: the deltas taking the graph
+// to resource version rv.
+type streamFrame struct {
+	RV     json.RawMessage   `json:"rv"`
+	Deltas []json.RawMessage `json:"deltas"`
+}
+
+// deltaKey returns the element a delta of a graph frame writes: a node by
+// UID or an edge by its ends and type. Deltas on another element, such as
+// scope changes, have no key.
+func deltaKey(delta json.RawMessage) string {
+	var d struct {
+		Op   string `json:"op"`
+		UID  string `json:"uid"`
+		From string `json:"from"`
+		To   string `json:"to"`
+		Type string `json:"type"`
+		Node struct {
+			UID string `json:"uid"`
+		} `json:"node"`
+		Edge struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+			Type string `json:"type"`
+		} `json:"edge"`
+	}
+	if err := json.Unmarshal(delta, &d); err != nil {
+		return ""
+	}
+	switch {
+	case d.Op == "upsert_node" && d.Node.UID != "":
+		return "node " + d.Node.UID
+	case d.Op == "remove_node" && d.UID != "":
+		return "node " + d.UID
+	case d.Op == "upsert_edge":
+		return fmt.Sprintf("edge %s %s %s", d.Edge.From, d.Edge.To, d.Edge.Type)
+	case d.Op == "remove_edge":
+		return fmt.Sprintf("edge %s %s %s", d.From, d.To, d.Type)
+	}
+	return ""
+}
+
+// streamCoalescer publishes at most one update per window. The first frame
+// of a burst opens the window and the frames received by the time it closes
+// are merged into one: their deltas are joined, the latest delta on an
+// element replacing the earlier ones, and sent under the newest rv. Frames
+// that aren't deltas can't be merged and are sent right away, after the
+// pending ones.
+type streamCoalescer struct {
+	window time.Duration
+	send   func([]byte) error
+
+	// mu serializes the sends so that the final flush in close can't race
+	// the one of the window timer.
+	mu sync.Mutex
+	// pending is the merged frame, or the single frame received as is.
+	pending *streamFrame
+	single  []byte
+	// keys indexes the deltas of pending by deltaKey. Replaced deltas are
+	// left nil.
+	keys  map[string]int
+	timer *time.Timer
+	err   error
+}
+
+// push merges update into the pending frame. It reports the error of a
+// previous send.
+func (c *streamCoalescer) push(update []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	var frame streamFrame
+	if err := json.Unmarshal(update, &frame); err != nil || frame.Deltas == nil {
+		c.sendPending()
+		if c.err == nil {
+			c.err = c.send(update)
+		}
+		return c.err
+	}
+
+	if c.pending == nil {
+		// The scanner reuses its buffer for the next line
+		c.single = bytes.Clone(update)
+		c.pending = &streamFrame{}
+		c.keys = map[string]int{}
+	} else {
+		c.single = nil
+	}
+	c.pending.RV = bytes.Clone(frame.RV)
+	for _, delta := range frame.Deltas {
+		key := deltaKey(delta)
+		if i, ok := c.keys[key]; ok && key != "" {
+			c.pending.Deltas[i] = nil
+		}
+		if key != "" {
+			c.keys[key] = len(c.pending.Deltas)
+		}
+		c.pending.Deltas = append(c.pending.Deltas, bytes.Clone(delta))
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.window, c.flush)
+	}
+	return nil
+}
+
+// flush sends the pending frame, if any, and closes the window.
+func (c *streamCoalescer) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timer = nil
+	c.sendPending()
+}
+
+// sendPending sends the pending frame unless a send failed. c.mu must be
+// held.
+func (c *streamCoalescer) sendPending() {
+	pending, single := c.pending, c.single
+	c.pending, c.single, c.keys = nil, nil, nil
+	if pending == nil || c.err != nil {
+		return
+	}
+	if single != nil {
+		c.err = c.send(single)
+		return
+	}
+	pending.Deltas = slices.DeleteFunc(pending.Deltas, func(delta json.RawMessage) bool { return delta == nil })
+	merged, err := json.Marshal(pending)
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.err = c.send(merged)
+}
+
+// close sends the pending frame right away and returns the error of any
+// send.
+func (c *streamCoalescer) close() error {
+	c.mu.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.mu.Unlock()
+	c.flush()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
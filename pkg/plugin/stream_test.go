@@ -0,0 +1,289 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// mockStreamPacketSender collects the packets sent to a stream.
+type mockStreamPacketSender struct {
+	packets chan *backend.StreamPacket
+}
+
+func (s *mockStreamPacketSender) Send(packet *backend.StreamPacket) error {
+	s.packets <- packet
+	return nil
+}
+
+// TestRunStreamRelaysGraphUpdates checks that every line of the upstream watch
+// is published and that the stream stops once the subscriber leaves.
+func TestRunStreamRelaysGraphUpdates(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/graph/watch" {
+			t.Errorf("upstream path should be /api/v1/graph/watch, got %q", r.URL.Path)
+		}
+		_, _ = w.Write([]byte("{\"added\":[\"a\"]}\n\n{\"removed\":[\"b\"]}\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+
+	res, err := app.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "graph"})
+	if err != nil || res.Status != backend.SubscribeStreamStatusOK {
+		t.Fatalf("subscription to graph should be allowed, got %v, %v", res, err)
+	}
+	res, err = app.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "other"})
+	if err != nil || res.Status != backend.SubscribeStreamStatusNotFound {
+		t.Fatalf("subscription to other should not be found, got %v, %v", res, err)
+	}
+
+	sender := &mockStreamPacketSender{packets: make(chan *backend.StreamPacket, 2)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- app.RunStream(ctx, &backend.RunStreamRequest{Path: "graph"}, backend.NewStreamSender(sender))
+	}()
+
+	for _, exp := range []string{`{"added":["a"]}`, `{"removed":["b"]}`} {
+		select {
+		case packet := <-sender.packets:
+			if string(packet.Data) != exp {
+				t.Errorf("packet should be %s, got %s", exp, packet.Data)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %s", exp)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("RunStream should stop cleanly, got %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("RunStream did not stop after the subscriber left")
+	}
+}
+
+// TestResourceStreams checks the subscriptions to the resource streams and
+// that their watch is scoped to the namespace of the stream path.
+func TestResourceStreams(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/resources/watch" {
+			t.Errorf("upstream path should be /api/v1/resources/watch, got %q", r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`{"namespace":"` + r.URL.Query().Get("namespace") + `"}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","orgNamespaceAllowlist":{"2":["team-a"]}}`)
+	for _, tc := range []struct {
+		path      string
+		orgID     int64
+		expStatus backend.SubscribeStreamStatus
+	}{
+		{path: "resources/default", orgID: 1, expStatus: backend.SubscribeStreamStatusOK},
+		{path: "resources/team-a", orgID: 2, expStatus: backend.SubscribeStreamStatusOK},
+		{path: "resources/default", orgID: 2, expStatus: backend.SubscribeStreamStatusPermissionDenied},
+		{path: "resources/", orgID: 1, expStatus: backend.SubscribeStreamStatusNotFound},
+		{path: "resources/a/b", orgID: 1, expStatus: backend.SubscribeStreamStatusNotFound},
+		{path: "graph", orgID: 1, expStatus: backend.SubscribeStreamStatusOK},
+		{path: "graph", orgID: 2, expStatus: backend.SubscribeStreamStatusPermissionDenied},
+	} {
+		res, err := app.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+			PluginContext: backend.PluginContext{OrgID: tc.orgID},
+			Path:          tc.path,
+		})
+		if err != nil || res.Status != tc.expStatus {
+			t.Errorf("subscription of org %d to %s should be %v, got %v, %v", tc.orgID, tc.path, tc.expStatus, res, err)
+		}
+	}
+
+	sender := &mockStreamPacketSender{packets: make(chan *backend.StreamPacket, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = app.RunStream(ctx, &backend.RunStreamRequest{Path: "resources/team-a"}, backend.NewStreamSender(sender))
+	}()
+	select {
+	case packet := <-sender.packets:
+		if exp := `{"namespace":"team-a"}`; string(packet.Data) != exp {
+			t.Errorf("packet should be %s, got %s", exp, packet.Data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a resource update")
+	}
+}
+
+// TestResourceStreamsNotCoalesced checks that every resource update is
+// published even with streamCoalesceMs set, since each one is about its own
+// resource.
+func TestResourceStreamsNotCoalesced(t *testing.T) {
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"uid":"a","status":"Running"}` + "\n" + `{"uid":"b","status":"Failed"}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","streamCoalesceMs":1000}`)
+	sender := &mockStreamPacketSender{packets: make(chan *backend.StreamPacket, 2)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = app.RunStream(ctx, &backend.RunStreamRequest{Path: "resources/default"}, backend.NewStreamSender(sender))
+	}()
+	for _, exp := range []string{`{"uid":"a","status":"Running"}`, `{"uid":"b","status":"Failed"}`} {
+		select {
+		case packet := <-sender.packets:
+			if string(packet.Data) != exp {
+				t.Errorf("packet should be %s, got %s", exp, packet.Data)
+			}
+		case <-time.After(500 * time.Millisecond):
+			t.Fatalf("timed out waiting for %s", exp)
+		}
+	}
+}
+
+// TestRunStreamGivesUp checks that the stream gives up after exactly
+// streamMaxFailures failed watches in a row and says so.
+func TestRunStreamGivesUp(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	app.reconnectBackoff = func(int) time.Duration { return 0 }
+	sender := &mockStreamPacketSender{packets: make(chan *backend.StreamPacket, 1)}
+	err := app.RunStream(context.Background(), &backend.RunStreamRequest{Path: "graph"}, backend.NewStreamSender(sender))
+
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("failed %d times", streamMaxFailures)) {
+		t.Errorf("RunStream should fail after %d attempts, got %v", streamMaxFailures, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != streamMaxFailures {
+		t.Errorf("watch attempts should be %d, got %d", streamMaxFailures, calls)
+	}
+	if len(sender.packets) != 1 {
+		t.Error("subscribers should be told the stream is unavailable")
+	}
+}
+
+func TestStreamBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		failures int
+		jitter   float64
+		exp      time.Duration
+	}{
+		{failures: 1, jitter: 0, exp: 500 * time.Millisecond},
+		{failures: 1, jitter: 0.5, exp: 750 * time.Millisecond},
+		{failures: 2, jitter: 0, exp: time.Second},
+		{failures: 3, jitter: 0.99, exp: 3980 * time.Millisecond},
+		{failures: 5, jitter: 0, exp: 8 * time.Second},
+		{failures: 6, jitter: 0, exp: 15 * time.Second},
+		{failures: 6, jitter: 0.5, exp: 22500 * time.Millisecond},
+		{failures: 64, jitter: 0, exp: 15 * time.Second},
+	} {
+		if got := streamBackoff(tc.failures, tc.jitter); got != tc.exp {
+			t.Errorf("backoff after %d failures with jitter %v should be %s, got %s", tc.failures, tc.jitter, tc.exp, got)
+		}
+	}
+}
+
+// TestStreamCoalescer checks that the frames of a window are merged into one
+// carrying the deltas of all of them, the latest per element, and that a
+// pending frame is flushed on close.
+func TestStreamCoalescer(t *testing.T) {
+	sent := make(chan string, 4)
+	c := &streamCoalescer{window: 20 * time.Millisecond, send: func(update []byte) error {
+		sent <- string(update)
+		return nil
+	}}
+	receive := func(name string) string {
+		t.Helper()
+		select {
+		case update := <-sent:
+			return update
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for the %s update", name)
+			return ""
+		}
+	}
+
+	// The scanner reuses its buffer for every line
+	var buf []byte
+	for _, frame := range []string{
+		`{"rv":"1","deltas":[{"op":"upsert_node","node":{"uid":"a","status":"Pending"}},{"op":"upsert_edge","edge":{"from":"a","to":"b","type":"owns"}}]}`,
+		`{"rv":"2","deltas":[{"op":"remove_node","uid":"c"},{"op":"upsert_node","node":{"uid":"a","status":"Running"}}]}`,
+	} {
+		buf = append(buf[:0], frame...)
+		if err := c.push(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp := `{"rv":"2","deltas":[{"op":"upsert_edge","edge":{"from":"a","to":"b","type":"owns"}},{"op":"remove_node","uid":"c"},{"op":"upsert_node","node":{"uid":"a","status":"Running"}}]}`
+	if update := receive("merged"); update != exp {
+		t.Errorf("merged update should be %s, got %s", exp, update)
+	}
+
+	// A single frame is sent as is, flushed on close
+	single := `{"rv":"3","deltas":[],"extra":true}`
+	if err := c.push([]byte(single)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sent); n != 1 {
+		t.Fatalf("close should send the pending update, got %d updates", n)
+	}
+	if update := receive("flushed"); update != single {
+		t.Errorf("flushed update should be %s, got %s", single, update)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if n := len(sent); n != 0 {
+		t.Errorf("no update should be sent after close, got %d", n)
+	}
+}
+
+// TestStreamCoalescerUnmergeable checks that frames without deltas are sent
+// right away, after the pending merged frame.
+func TestStreamCoalescerUnmergeable(t *testing.T) {
+	var sent []string
+	c := &streamCoalescer{window: time.Hour, send: func(update []byte) error {
+		sent = append(sent, string(update))
+		return nil
+	}}
+	for _, frame := range []string{`{"rv":"1","deltas":[]}`, `{"error":"resync"}`} {
+		if err := c.push([]byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exp := []string{`{"rv":"1","deltas":[]}`, `{"error":"resync"}`}; !slices.Equal(sent, exp) {
+		t.Errorf("updates should be %v, got %v", exp, sent)
+	}
+	if err := c.close(); err != nil {
+		t.Fatal(err)
+	}
+}
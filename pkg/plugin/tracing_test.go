@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestProxyTracing checks that a span is recorded per proxied request and its
+// context is propagated to the state server.
+func TestProxyTracing(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	defer func() { _ = tp.Shutdown(t.Context()) }()
+	tracing.InitDefaultTracer(tp.Tracer("test"))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var gotTraceparent string
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTraceparent = r.Header.Get("traceparent")
+	}))
+	defer indexer.Close()
+
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+	app.proxyToIndexer(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/graph", nil), "graph", "")
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("one span should be recorded, got %d", len(ended))
+	}
+	if ended[0].Name() != "proxy graph" {
+		t.Errorf("span name should be %q, got %q", "proxy graph", ended[0].Name())
+	}
+	traceID := ended[0].SpanContext().TraceID().String()
+	if gotTraceparent == "" || gotTraceparent[3:35] != traceID {
+		t.Errorf("upstream traceparent should carry trace %s, got %q", traceID, gotTraceparent)
+	}
+}
+
+// TestProxyForwardsTraceHeaders checks that the client's trace context and
+// baggage reach the state server, even when listed as hop-by-hop headers.
+func TestProxyForwardsTraceHeaders(t *testing.T) {
+	const (
+		traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+		traceparent = "00-" + traceID + "-00f067aa0ba902b7-01"
+		tracestate  = "vendor=value"
+		baggage     = "team=platform"
+	)
+	prev := otel.GetTextMapPropagator()
+	defer otel.SetTextMapPropagator(prev)
+
+	var got http.Header
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer indexer.Close()
+	app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`"}`)
+
+	for _, tc := range []struct {
+		name       string
+		propagator propagation.TextMapPropagator
+	}{
+		{name: "without propagator", propagator: propagation.NewCompositeTextMapPropagator()},
+		{name: "with propagator", propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spans := tracetest.NewSpanRecorder()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+			defer func() { _ = tp.Shutdown(t.Context()) }()
+			tracing.InitDefaultTracer(tp.Tracer("test"))
+			otel.SetTextMapPropagator(tc.propagator)
+
+			req := httptest.NewRequest(http.MethodGet, "/graph", nil)
+			req.Header.Set("Connection", "traceparent, tracestate, baggage")
+			req.Header.Set("traceparent", traceparent)
+			req.Header.Set("tracestate", tracestate)
+			req.Header.Set("baggage", baggage)
+			app.proxyToIndexer(httptest.NewRecorder(), req, "graph", "")
+
+			if v := got.Get("traceparent"); len(v) < 35 || v[3:35] != traceID {
+				t.Errorf("upstream traceparent should carry trace %s, got %q", traceID, v)
+			}
+			if v := got.Get("tracestate"); v != tracestate {
+				t.Errorf("upstream tracestate should be %q, got %q", tracestate, v)
+			}
+			if v := got.Get("baggage"); v != baggage {
+				t.Errorf("upstream baggage should be %q, got %q", baggage, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// rewriteJSONBody replaces the body of a successful JSON upstream response
+// with the result of rewrite. The body is decompressed first if needed, so
+// the rewritten response is always sent uncompressed.
+func rewriteJSONBody(resp *http.Response, rewrite func([]byte) ([]byte, error)) error {
+	if resp.StatusCode != http.StatusOK || !isJSONContentType(resp.Header.Get("Content-Type")) {
+		return nil
+	}
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		if err := decompressResponse(resp); err != nil {
+			return err
+		}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if body, err = rewrite(body); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidUpstreamResponse, err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// replaceBody replaces an upstream response with a JSON body and status.
+func replaceBody(resp *http.Response, status int, body []byte) {
+	resp.Body.Close()
+	resp.StatusCode = status
+	resp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// filterByKind returns a rewrite keeping only the items of a resource list
+// with the given kind. The list is either a JSON array or an object holding
+// the array in "items".
+func filterByKind(kind string) func([]byte) ([]byte, error) {
+	return func(body []byte) ([]byte, error) {
+		var list map[string]json.RawMessage
+		if err := json.Unmarshal(body, &list); err == nil {
+			items, err := filterItemsByKind(list["items"], kind)
+			if err != nil {
+				return nil, err
+			}
+			list["items"] = items
+			return json.Marshal(list)
+		}
+		return filterItemsByKind(body, kind)
+	}
+}
+
+// filterItemsByKind keeps the elements of a JSON array whose kind matches.
+func filterItemsByKind(data json.RawMessage, kind string) (json.RawMessage, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("resource list is not an array: %w", err)
+	}
+
+	kept := make([]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		var meta struct {
+			Kind string `json:"kind"`
+		}
+		if err := json.Unmarshal(item, &meta); err != nil {
+			return nil, err
+		}
+		if meta.Kind == kind {
+			kept = append(kept, item)
+		}
+	}
+	return json.Marshal(kept)
+}
+
+// filterGraphByRelease returns a rewrite keeping only the nodes of a graph
+// that belong to the given release, along with the edges between them. Other
+// keys of the graph are left as is.
+func filterGraphByRelease(release string) func([]byte) ([]byte, error) {
+	return func(body []byte) ([]byte, error) {
+		var graph map[string]json.RawMessage
+		if err := json.Unmarshal(body, &graph); err != nil {
+			return nil, fmt.Errorf("graph is not an object: %w", err)
+		}
+		var nodes, edges []json.RawMessage
+		if err := json.Unmarshal(graph["nodes"], &nodes); err != nil {
+			return nil, fmt.Errorf("graph nodes are not an array: %w", err)
+		}
+		if err := json.Unmarshal(graph["edges"], &edges); err != nil {
+			return nil, fmt.Errorf("graph edges are not an array: %w", err)
+		}
+
+		kept := map[string]bool{}
+		keptNodes := make([]json.RawMessage, 0, len(nodes))
+		for _, node := range nodes {
+			var meta struct {
+				UID     string `json:"uid"`
+				Release string `json:"release"`
+			}
+			if err := json.Unmarshal(node, &meta); err != nil {
+				return nil, err
+			}
+			if meta.Release == release {
+				kept[meta.UID] = true
+				keptNodes = append(keptNodes, node)
+			}
+		}
+		keptEdges := make([]json.RawMessage, 0, len(edges))
+		for _, edge := range edges {
+			var meta struct {
+				From string `json:"from"`
+				To   string `json:"to"`
+			}
+			if err := json.Unmarshal(edge, &meta); err != nil {
+				return nil, err
+			}
+			if kept[meta.From] && kept[meta.To] {
+				keptEdges = append(keptEdges, edge)
+			}
+		}
+
+		var err error
+		if graph["nodes"], err = json.Marshal(keptNodes); err != nil {
+			return nil, err
+		}
+		if graph["edges"], err = json.Marshal(keptEdges); err != nil {
+			return nil, err
+		}
+		return json.Marshal(graph)
+	}
+}
@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// unixSocketHostSuffix ends the hosts that unix:// state server URLs are
+// rewritten to. The host holds the hex encoded socket path, so requests to
+// different sockets are pooled separately and the transport learns the path
+// to dial from the address alone.
+const unixSocketHostSuffix = ".socket.invalid"
+
+// parseUnixSocketURL turns a unix:///path/to/socket state server URL into the
+// http URL requests are made to.
+func parseUnixSocketURL(u *url.URL) (*url.URL, error) {
+	if u.Host != "" {
+		return nil, fmt.Errorf("%q must not have a host, as in unix:///var/run/astrolabe.sock", u.String())
+	}
+	if !filepath.IsAbs(u.Path) || filepath.Clean(u.Path) != u.Path {
+		return nil, fmt.Errorf("%q must have an absolute, clean socket path", u.String())
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("%q must not have a query or fragment", u.String())
+	}
+	return &url.URL{Scheme: "http", Host: hex.EncodeToString([]byte(u.Path)) + unixSocketHostSuffix, Path: "/"}, nil
+}
+
+// unixSocketPath returns the socket path of a host, optionally with a port,
+// created by parseUnixSocketURL.
+func unixSocketPath(hostport string) (string, bool) {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	encoded, ok := strings.CutSuffix(host, unixSocketHostSuffix)
+	if !ok {
+		return "", false
+	}
+	path, err := hex.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(path), true
+}
+
+// dialUnixSockets wraps dial to connect to the socket of unix:// state
+// servers instead of resolving their host.
+func dialUnixSockets(dial func(context.Context, string, string) (net.Conn, error)) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if path, ok := unixSocketPath(addr); ok {
+			return dial(ctx, "unix", path)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// proxyFromEnvironment is http.ProxyFromEnvironment, except that requests
+// to unix:// state servers are never proxied.
+func proxyFromEnvironment(req *http.Request) (*url.URL, error) {
+	if _, ok := unixSocketPath(req.URL.Host); ok {
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// TestUnixSocket checks that requests reach a state server listening on a
+// Unix domain socket and that CheckHealth fails once it is gone.
+func TestUnixSocket(t *testing.T) {
+	// Socket paths are limited to about 100 bytes, which t.TempDir may exceed
+	dir, err := os.MkdirTemp("", "astrolabe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "state.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`["` + r.URL.Path + `"]`))
+	}))
+	indexer.Listener = listener
+	indexer.Start()
+
+	app := newTestApp(t, `{"indexerUrl":"unix://`+socket+`","maxRetries":0}`)
+
+	rec := httptest.NewRecorder()
+	app.proxyToIndexer(rec, httptest.NewRequest(http.MethodGet, "/namespaces", nil), "namespaces", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("response status should be %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if exp := `["/api/v1/namespaces"]`; rec.Body.String() != exp {
+		t.Errorf("body should be %s, got %s", exp, rec.Body.String())
+	}
+
+	res, err := app.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatalf("CheckHealth error: %s", err)
+	}
+	if res.Status != backend.HealthStatusOk {
+		t.Errorf("health status should be ok, got %s (%s)", res.Status, res.Message)
+	}
+
+	indexer.Close()
+	res, err = app.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatalf("CheckHealth error: %s", err)
+	}
+	if res.Status != backend.HealthStatusError || !strings.Contains(res.Message, socket) {
+		t.Errorf("health check should fail naming the socket, got %s (%s)", res.Status, res.Message)
+	}
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// upstreamVersionTTL is how long the state server version is cached for
+// /version.
+const upstreamVersionTTL = 30 * time.Second
+
+// versionResponse is returned by /version. The state server version is empty
+// if it could not be fetched, with the reason in StateServerError.
+type versionResponse struct {
+	Plugin           string `json:"plugin"`
+	StateServer      string `json:"stateServer,omitempty"`
+	StateServerError string `json:"stateServerError,omitempty"`
+}
+
+// versionCache holds the last state server version fetched, or the error
+// fetching it, for upstreamVersionTTL.
+type versionCache struct {
+	mu      sync.Mutex
+	version string
+	err     error
+	fetched time.Time
+}
+
+// get returns the cached version, calling fetch once it is stale. Concurrent
+// callers wait for the same fetch. Fetches aborted by the caller going away
+// are not cached.
+func (c *versionCache) get(ctx context.Context, fetch func(context.Context) (string, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.fetched.IsZero() && time.Since(c.fetched) < upstreamVersionTTL {
+		return c.version, c.err
+	}
+	version, err := fetch(ctx)
+	if ctx.Err() == nil {
+		c.version, c.err, c.fetched = version, err, time.Now()
+	}
+	return version, err
+}
+
+// fetchVersion returns the version reported by the default state server,
+// either as {"version": ...} or as a JSON string.
+func (a *App) fetchVersion(ctx context.Context) (string, error) {
+	target, err := a.settings.resolve(a.settings.indexerURL, a.settings.apiPath("version", ""))
+	if err != nil {
+		return "", err
+	}
+	_, body, err := a.fetch(ctx, "version", target)
+	if err != nil {
+		return "", err
+	}
+	var info struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(body, &info); err == nil {
+		return info.Version, nil
+	}
+	var version string
+	if err := json.Unmarshal(body, &version); err != nil {
+		return "", errInvalidUpstreamResponse
+	}
+	return version, nil
+}
+
+// handleVersion reports the plugin build version and the version of the
+// default state server, which is cached briefly.
+func (a *App) handleVersion(w http.ResponseWriter, req *http.Request) {
+	resp := versionResponse{Plugin: pluginVersion()}
+	if a.settingsErr != nil {
+		resp.StateServerError = a.settingsErr.Error()
+	} else if version, err := a.versions.get(req.Context(), a.fetchVersion); err != nil {
+		resp.StateServerError = err.Error()
+	} else {
+		resp.StateServer = version
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.DefaultLogger.Error("Failed to write version response", "error", err)
+	}
+}
@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// TestHandleVersion checks that /version reports the plugin and state server
+// versions and that the latter is cached.
+func TestHandleVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		body       string
+		status     int
+		expVersion string
+	}{
+		{name: "object", body: `{"version":"1.4.0","commit":"abc"}`, status: http.StatusOK, expVersion: "1.4.0"},
+		{name: "string", body: `"1.4.0"`, status: http.StatusOK, expVersion: "1.4.0"},
+		{name: "no version endpoint", status: http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int32
+			indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v1/version" {
+					t.Errorf("upstream path should be /api/v1/version, got %q", r.URL.Path)
+				}
+				calls.Add(1)
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer indexer.Close()
+
+			app := newTestApp(t, `{"indexerUrl":"`+indexer.URL+`","maxRetries":0}`)
+			mux := http.NewServeMux()
+			if err := app.registerRoutes(mux); err != nil {
+				t.Fatal(err)
+			}
+
+			for range 2 {
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status should be %d, got %d", http.StatusOK, rec.Code)
+				}
+				var resp versionResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Plugin != pluginVersion() {
+					t.Errorf("plugin version should be %q, got %q", pluginVersion(), resp.Plugin)
+				}
+				if resp.StateServer != tc.expVersion {
+					t.Errorf("state server version should be %q, got %q", tc.expVersion, resp.StateServer)
+				}
+				if (resp.StateServerError == "") != (tc.expVersion != "") {
+					t.Errorf("state server error should be set only without a version, got %q", resp.StateServerError)
+				}
+			}
+			if n := calls.Load(); n != 1 {
+				t.Errorf("state server version should be fetched once, got %d calls", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Backoff between DNS lookups while warming up.
+const (
+	warmupInitialBackoff = 250 * time.Millisecond
+	warmupMaxBackoff     = 2 * time.Second
+)
+
+// warmUp waits for the state server hosts to resolve, which may take a while
+// right after a Kubernetes rollout, so the first requests don't fail. It gives
+// up after timeout and never fails the instance; ready is closed once done.
+func (a *App) warmUp(ctx context.Context, timeout time.Duration) {
+	defer close(a.ready)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	hosts := []string{a.settings.indexerURL.Hostname()}
+	for _, u := range a.settings.clusterURLs {
+		hosts = append(hosts, u.Hostname())
+	}
+	for _, host := range hosts {
+		if err := waitForHost(ctx, net.DefaultResolver.LookupHost, host); err != nil {
+			log.DefaultLogger.Warn("State server host did not resolve during warm-up", "host", host, "error", err)
+		}
+	}
+}
+
+// waitForHost retries resolving host with lookup and backoff until it
+// succeeds or ctx is done. IP addresses and Unix sockets are returned for
+// immediately.
+func waitForHost(ctx context.Context, lookup func(context.Context, string) ([]string, error), host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if _, ok := unixSocketPath(host); ok {
+		return nil
+	}
+
+	backoff := warmupInitialBackoff
+	for {
+		_, err := lookup(ctx, host)
+		if err == nil {
+			return nil
+		}
+		log.DefaultLogger.Debug("Waiting for state server host to resolve", "host", host, "error", err, "backoff", backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, warmupMaxBackoff)
+	}
+}
+
+// waitReady blocks until warm-up has finished or ctx is done.
+func (a *App) waitReady(ctx context.Context) {
+	if a.ready == nil {
+		return
+	}
+	select {
+	case <-a.ready:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForHost(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		host       string
+		failures   int
+		timeout    time.Duration
+		expErr     bool
+		expLookups int
+	}{
+		{name: "ip address", host: "127.0.0.1", timeout: time.Second, expLookups: 0},
+		{name: "resolves", host: "astrolabe", timeout: time.Second, expLookups: 1},
+		{name: "resolves after retry", host: "astrolabe", failures: 2, timeout: 5 * time.Second, expLookups: 3},
+		{name: "times out", host: "astrolabe", failures: 100, timeout: 100 * time.Millisecond, expErr: true, expLookups: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lookups := 0
+			lookup := func(ctx context.Context, host string) ([]string, error) {
+				lookups++
+				if lookups <= tc.failures {
+					return nil, errors.New("no such host")
+				}
+				return []string{"10.0.0.1"}, nil
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+			err := waitForHost(ctx, lookup, tc.host)
+
+			if gotErr := err != nil; gotErr != tc.expErr {
+				t.Errorf("error should be %t, got %v", tc.expErr, err)
+			}
+			if lookups != tc.expLookups {
+				t.Errorf("lookups should be %d, got %d", tc.expLookups, lookups)
+			}
+		})
+	}
+}
@@ -45,13 +45,12 @@ export class IndexerClient {
   }
 
   /**
-   * List all resource kinds
-   * Note: This endpoint doesn't exist in Astrolabe yet
+   * List the resource kinds indexed by Astrolabe
+   * Old servers without the endpoint return an empty list
    */
-  async listKinds(): Promise<KindInfo[]> {
-    // TODO: Add this endpoint to Astrolabe
-    // For now, return empty array
-    return [];
+  async listKinds(ns?: string): Promise<KindInfo[]> {
+    const params = ns ? { namespace: ns } : undefined;
+    return this.fetchViaBackend('/kinds', params);
   }
 
   /**